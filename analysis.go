@@ -0,0 +1,291 @@
+package ego
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector returns the selector of the message sent by e, as Self names
+// methods: "foo" for a unary message, "+" for a binary message and "at:Put:"
+// for a keyword message. The delegate of a resend is not part of the
+// selector. If e is not a message send, Selector returns "".
+func Selector(e expr) string {
+	switch e := e.(type) {
+	case *unary:
+		return e.selector
+	case *binary:
+		return e.operator
+	case *keyword:
+		return strings.Join(e.keywords, "")
+	}
+	return ""
+}
+
+// delegate returns the delegate of a message send, or "" if e has none.
+func delegate(e expr) string {
+	switch e := e.(type) {
+	case *unary:
+		return e.delegate
+	case *binary:
+		return e.delegate
+	case *keyword:
+		return e.delegate
+	}
+	return ""
+}
+
+// A DelegatedSend is a message sent through a directed resend such as
+// "parent.foo" or an undirected one such as "resend.foo".
+type DelegatedSend struct {
+	Delegate string // parent slot name, or "resend"
+	Selector string // selector of the message
+	Pos      int    // byte offset of the delegate
+}
+
+// DelegatedSends returns the delegated message sends in e in source order.
+func DelegatedSends(e expr) (sends []DelegatedSend) {
+	Walk(e, func(e expr) bool {
+		if d := delegate(e); d != "" {
+			sends = append(sends, DelegatedSend{d, Selector(e), e.Pos()})
+		}
+		return true
+	})
+	return
+}
+
+// Receiver returns the receiver of e and true if e is a message send, or nil
+// and false if it is not. The receiver of a send to implicit self, such as
+// "foo" or a resend, is nil.
+func Receiver(e expr) (expr, bool) {
+	switch e := e.(type) {
+	case *unary:
+		return e.receiver, true
+	case *binary:
+		return e.receiver, true
+	case *keyword:
+		return e.receiver, true
+	}
+	return nil, false
+}
+
+// LongestChain returns the length of the longest chain of message sends in
+// e, each sent to the result of the one before: "a b c d" is a chain of four.
+// It returns 0 if e sends no messages.
+func LongestChain(e expr) (longest int) {
+	Walk(e, func(e expr) bool {
+		n := 0
+		for ; Selector(e) != ""; e, _ = Receiver(e) {
+			n++
+		}
+		if n > longest {
+			longest = n
+		}
+		return true
+	})
+	return
+}
+
+// MaxKeywordArity returns the largest number of arguments taken by a keyword
+// message sent in e, or 0 if e sends none.
+func MaxKeywordArity(e expr) (max int) {
+	Walk(e, func(e expr) bool {
+		if k, ok := e.(*keyword); ok && len(k.arguments) > max {
+			max = len(k.arguments)
+		}
+		return true
+	})
+	return
+}
+
+// A Signature describes a method slot.
+type Signature struct {
+	Selector string   // selector, such as "at:Put:"
+	Params   []string // parameter names, in order
+}
+
+// Methods returns the signatures of the method slots of the object literal
+// obj, in order. A slot is a method slot if it is a binary or keyword slot,
+// or if it is a unary data slot whose value is an object with statements.
+func Methods(obj expr) (sigs []Signature) {
+	o, ok := obj.(*object)
+	if !ok {
+		return nil
+	}
+	for _, s := range o.slots {
+		m, ok := s.value.(*object)
+		if !ok || s.kind != dataSlot || len(m.body) == 0 && !isMethodSelector(s.name) {
+			continue
+		}
+		params := s.params
+		if params == nil {
+			for _, arg := range m.slots {
+				if arg.kind == argumentSlot {
+					params = append(params, arg.name)
+				}
+			}
+		}
+		sigs = append(sigs, Signature{s.name, params})
+	}
+	return
+}
+
+// isMethodSelector reports whether name is a binary or keyword selector.
+func isMethodSelector(name string) bool {
+	return strings.HasSuffix(name, ":") || !strings.ContainsAny(name[:1], identifierStart)
+}
+
+// SlotNames returns the names of the slots declared by the object or block
+// literal e, in order. Method slots are named by their selectors.
+func SlotNames(e expr) (names []string) {
+	var slots []*slot
+	switch e := e.(type) {
+	case *object:
+		slots = e.slots
+	case *block:
+		slots = e.slots
+	}
+	for _, s := range slots {
+		names = append(names, s.name)
+	}
+	return
+}
+
+// A Warning describes legal but suspicious code.
+type Warning struct {
+	Pos int    // byte offset of the code
+	Msg string // description of the problem
+}
+
+func (w Warning) String() string { return fmt.Sprintf("%d: %s", w.Pos, w.Msg) }
+
+// CyclicParents returns a warning for each parent slot in e that makes an
+// object its own parent, either by referring to self or by sending the name
+// of the slot holding the object, as in "foo = (| parent* = foo |)".
+func CyclicParents(e expr) (warnings []Warning) {
+	Walk(e, func(e expr) bool {
+		o, ok := e.(*object)
+		if !ok {
+			return true
+		}
+		for _, s := range o.slots {
+			if _, ok := s.value.(*selfExpr); ok && s.parent {
+				warnings = append(warnings, Warning{s.pos, "parent slot '" + s.name + "' refers to self"})
+			}
+			v, ok := s.value.(*object)
+			if !ok {
+				continue
+			}
+			for _, ps := range v.slots {
+				if u, ok := ps.value.(*unary); ok && ps.parent && u.receiver == implicitSelf && u.delegate == "" && u.selector == s.name {
+					warnings = append(warnings, Warning{ps.pos, "parent slot '" + ps.name + "' refers to its own object '" + s.name + "'"})
+				}
+			}
+		}
+		return true
+	})
+	return
+}
+
+// ShadowedSlots returns a warning for each slot or argument of a block in e
+// with the same name as a slot of an enclosing object, method or block. The
+// message gives the position of the shadowed slot.
+func ShadowedSlots(e expr) (warnings []Warning) {
+	walkScoped(e, nil, func(e expr, scope map[string]int) {
+		b, ok := e.(*block)
+		if !ok {
+			return
+		}
+		for _, s := range b.slots {
+			if pos, ok := scope[s.name]; ok {
+				warnings = append(warnings, Warning{s.pos, fmt.Sprintf("slot '%s' shadows the slot declared at %d", s.name, pos)})
+			}
+		}
+	})
+	return
+}
+
+// A Reference is a use of a name.
+type Reference struct {
+	Name string // name referred to
+	Pos  int    // byte offset of the reference
+}
+
+// FreeVariables returns the names in e, sent as unary messages to implicit
+// self, that are not bound by the slots or arguments of an enclosing object
+// or block, or by the parameters of an enclosing method, in source order.
+func FreeVariables(e expr) (refs []Reference) {
+	walkScoped(e, nil, func(e expr, scope map[string]int) {
+		if u, ok := e.(*unary); ok && u.receiver == implicitSelf && u.delegate == "" {
+			if _, ok := scope[u.selector]; !ok {
+				refs = append(refs, Reference{u.selector, u.pos})
+			}
+		}
+	})
+	return
+}
+
+// walkScoped is like Walk, but never skips children and also passes fn the
+// names in scope at each node, those of the slots and arguments of enclosing
+// objects and blocks and the parameters of enclosing methods, mapped to the
+// positions of their declarations. Slots are in scope in the values of their
+// sibling slots as well as in the body of their object or block.
+func walkScoped(e expr, scope map[string]int, fn func(e expr, scope map[string]int)) {
+	Walk(e, func(e expr) bool {
+		fn(e, scope)
+		var slots []*slot
+		var body []expr
+		switch e := e.(type) {
+		case *object:
+			slots, body = e.slots, e.body
+		case *block:
+			slots, body = e.slots, e.body
+		default:
+			return true
+		}
+		inner := make(map[string]int, len(scope)+len(slots))
+		for name, pos := range scope {
+			inner[name] = pos
+		}
+		for _, s := range slots {
+			inner[s.name] = s.pos
+		}
+		for _, s := range slots {
+			if s.params == nil {
+				walkScoped(s.value, inner, fn)
+				continue
+			}
+			// The parameters of a method slot are in scope in its method.
+			method := make(map[string]int, len(inner)+len(s.params))
+			for name, pos := range inner {
+				method[name] = pos
+			}
+			for _, name := range s.params {
+				method[name] = s.pos
+			}
+			walkScoped(s.value, method, fn)
+		}
+		for _, e := range body {
+			walkScoped(e, inner, fn)
+		}
+		return false
+	})
+}
+
+// A Literal is a literal in the source.
+type Literal struct {
+	Text string // source text of the literal
+	Pos  int    // byte offset of the literal
+}
+
+// InexactFloats returns the real number literals in e, in source order, whose
+// decimal value cannot be represented exactly as a float64, such as "0.1".
+// Reals too large for a float64 are included.
+func InexactFloats(e expr) (lits []Literal) {
+	Walk(e, func(e expr) bool {
+		if n, ok := e.(*numberLit); ok && !n.isInt() && !n.exact() {
+			lits = append(lits, Literal{n.text, n.pos})
+		}
+		return true
+	})
+	return
+}
@@ -0,0 +1,255 @@
+package ego
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDelegatedSends(t *testing.T) {
+	source := "resend.at: a Put: parent.b + c foo: resend.+ d"
+	p := parse("test", source, Config{})
+	defer p.close()
+	sends := DelegatedSends(p.parseExpr())
+	expected := []DelegatedSend{
+		{"resend", "at:Put:", 0},
+		{"parent", "b", 18},
+		{"resend", "+", 36},
+	}
+	if !reflect.DeepEqual(sends, expected) {
+		t.Errorf("expected %v but found %v", expected, sends)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	source := `(|
+		x = y.
+		z <- w.
+		data = (| a = b |).
+		foo = (bar baz).
+		+ other = (other qux).
+		at: i Put: v = (i store: v).
+		put:At: = (| :v. :i | i store: v).
+	|)`
+	p := parse("test", source, Config{})
+	defer p.close()
+	sigs := Methods(p.parseExpr())
+	for _, err := range p.errors {
+		t.Errorf("unexpected error: %v", err)
+	}
+	expected := []Signature{
+		{"foo", nil},
+		{"+", []string{"other"}},
+		{"at:Put:", []string{"i", "v"}},
+		{"put:At:", []string{"v", "i"}},
+	}
+	if !reflect.DeepEqual(sigs, expected) {
+		t.Errorf("expected %v but found %v", expected, sigs)
+	}
+}
+
+func TestSlotNames(t *testing.T) {
+	tests := []struct {
+		source string
+		names  []string
+	}{
+		{"(| x = y. z <- w. parent = p. :arg. + a = (a). at: i Put: v = (v). tmp | x)", []string{"x", "z", "parent", "arg", "+", "at:Put:", "tmp"}},
+		{"[:a :b | | t | a]", []string{"a", "b", "t"}},
+		{"(a foo)", nil},
+		{"a foo", nil},
+	}
+	for _, test := range tests {
+		if names := SlotNames(parseExpr(t, test.source)); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%q: expected %v but found %v", test.source, test.names, names)
+		}
+	}
+}
+
+func TestCyclicParents(t *testing.T) {
+	source := "(| parent* = self. traits* = lobby. foo = (| p* = foo. q* = bar |). bar <- (| parent* <- foo |) |)"
+	expected := []Warning{
+		{3, "parent slot 'parent' refers to self"},
+		{45, "parent slot 'p' refers to its own object 'foo'"},
+	}
+	p := parse("test", source, Config{})
+	defer p.close()
+	if warnings := CyclicParents(p.parseProgram()); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("expected %v but found %v", expected, warnings)
+	}
+}
+
+func TestShadowedSlots(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected []Warning
+	}{
+		{"(| x. foo = (| y | [:x | x] value: y) |)", []Warning{{20, "slot 'x' shadows the slot declared at 3"}}},
+		{"(| foo: a = ([| a. b | [:b | b] ]) |)", []Warning{{16, "slot 'a' shadows the slot declared at 3"}, {24, "slot 'b' shadows the slot declared at 19"}}},
+		{"(| x. foo = (| y | [:z | | w | z] value: y) |)", nil},
+		{"[:x | x]. [:x | x]", nil},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{})
+		e := p.parseProgram()
+		p.close()
+		if warnings := ShadowedSlots(e); !reflect.DeepEqual(warnings, test.expected) {
+			t.Errorf("%q: expected %v but found %v", test.source, test.expected, warnings)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	tests := []struct {
+		source   string
+		selector string
+	}{
+		{"foo", "foo"},
+		{"a foo", "foo"},
+		{"resend.foo", "foo"},
+		{"parent.foo", "foo"},
+		{"a + b", "+"},
+		{"resend.+ b", "+"},
+		{"a <= b", "<="},
+		{"a at: b Put: c", "at:Put:"},
+		{"at: b", "at:"},
+		{"resend.at: b Put: c", "at:Put:"},
+		{"parent.at: b", "at:"},
+		{"(a foo)", ""},
+		{"3", ""},
+	}
+	for _, test := range tests {
+		if s := Selector(parseExpr(t, test.source)); s != test.selector {
+			t.Errorf("%q: expected %q but found %q", test.source, test.selector, s)
+		}
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	a := send(nil, "a")
+	tests := []struct {
+		source   string
+		receiver expr
+		ok       bool
+	}{
+		{"a foo", a, true},
+		{"foo", nil, true},
+		{"self foo", &selfExpr{}, true},
+		{"resend.foo", nil, true},
+		{"a + b", a, true},
+		{"+ b", nil, true},
+		{"a at: b", a, true},
+		{"at: b", nil, true},
+		{"parent.at: b", nil, true},
+		{"(a foo)", nil, false},
+		{"[a foo]", nil, false},
+		{"3", nil, false},
+		{"'a'", nil, false},
+		{"self", nil, false},
+	}
+	for _, test := range tests {
+		r, ok := Receiver(parseExpr(t, test.source))
+		if ok != test.ok || !reflect.DeepEqual(r, test.receiver) {
+			t.Errorf("%q: expected %#v, %t but found %#v, %t", test.source, test.receiver, test.ok, r, ok)
+		}
+	}
+	if r, ok := Receiver(&returnStmt{a, 0}); r != nil || ok {
+		t.Errorf("expected no receiver for a return but found %#v, %t", r, ok)
+	}
+}
+
+func TestLongestChain(t *testing.T) {
+	tests := []struct {
+		source string
+		length int
+	}{
+		{"3", 0},
+		{"(| x = 3 |)", 0},
+		{"a", 1},
+		{"3 foo", 1},
+		{"a b c d", 4},
+		{"a b + c", 3},
+		{"a b + c d e f", 4},
+		{"a at: b c Put: d", 2},
+		{"(a b) c", 2},
+		{"(| x = (a b c) |)", 3},
+		{"[:x | x y z] value: 4", 3},
+		{"resend.foo bar", 2},
+	}
+	for _, test := range tests {
+		if n := LongestChain(parseExpr(t, test.source)); n != test.length {
+			t.Errorf("%q: expected a chain of %d but found %d", test.source, test.length, n)
+		}
+	}
+}
+
+func TestFreeVariables(t *testing.T) {
+	source := `(| x = 3. y.
+		+ n = (x + n + z).
+		at: i Put: v = ([:k | k + i + v + w] value: y).
+	| x + q. [:x | x + r] )`
+	p := parse("test", source, Config{})
+	defer p.close()
+	refs := FreeVariables(p.parseExpr())
+	for _, err := range p.errors {
+		t.Errorf("unexpected error: %v", err)
+	}
+	expected := []Reference{{"z", 30}, {"w", 70}, {"q", 91}, {"r", 104}}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("expected %v but found %v", expected, refs)
+	}
+
+	p = parse("test", "a foo: self bar. resend.b. 3 c", Config{})
+	defer p.close()
+	if refs := FreeVariables(p.parseProgram()); !reflect.DeepEqual(refs, []Reference{{"a", 0}}) {
+		t.Errorf("expected only a to be free but found %v", refs)
+	}
+}
+
+func TestMaxKeywordArity(t *testing.T) {
+	tests := []struct {
+		source string
+		arity  int
+	}{
+		{"a foo + b", 0},
+		{"a at: 1", 1},
+		{"a at: 1 Put: 2. b at: 3", 2},
+		{"a at: 1. b foo: 1 Bar: 2 Baz: 3", 3},
+		{"a at: (b foo: 1 Bar: 2 Baz: 3)", 3},
+		{"(| m: x = ([:y | y a: 1 B: 2 C: 3 D: 4]) |)", 4},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{})
+		e := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", test.source, err)
+		}
+		if n := MaxKeywordArity(e); n != test.arity {
+			t.Errorf("%q: expected %d but found %d", test.source, test.arity, n)
+		}
+	}
+}
+
+func TestInexactFloats(t *testing.T) {
+	tests := []struct {
+		source string
+		lits   []Literal
+	}{
+		{"0.1", []Literal{{"0.1", 0}}},
+		{"0.5", nil},
+		{"a at: 0.1 Put: 0.25 + 1.1e-3 + 2.5e2", []Literal{{"0.1", 6}, {"1.1e-3", 22}}},
+		{"1 + 16rFF + 1e3 + -0.0 + 0e-999999", nil},
+		{"1e400. 1e-400. -0.3", []Literal{{"1e400", 0}, {"1e-400", 7}, {"-0.3", 15}}},
+		{"(| x = 0.2 | [0.75. 0.7])", []Literal{{"0.2", 7}, {"0.7", 20}}},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{})
+		e := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", test.source, err)
+		}
+		if lits := InexactFloats(e); !reflect.DeepEqual(lits, test.lits) {
+			t.Errorf("%q: expected %v but found %v", test.source, test.lits, lits)
+		}
+	}
+}
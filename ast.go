@@ -1,17 +1,309 @@
 package ego
 
-type expr interface{}
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// A Node is an expression or statement in a syntax tree built by Parse.
+type Node interface {
+	Pos() int // byte offset of the node; see the node types for details
+	End() int // byte offset just past the node
+}
+
+type expr = Node
+
+// Span returns the byte offsets of the start of n, including the receiver of
+// a send, and of just past its end. The end of a node missing a part because
+// of a syntax error is that of its last part present.
+func Span(n Node) (start, end int) {
+	return spanStart(n), n.End()
+}
+
+func spanStart(e expr) int {
+	switch e := e.(type) {
+	case *unary:
+		if e.receiver != implicitSelf && e.delegate == "" {
+			return spanStart(e.receiver)
+		}
+	case *binary:
+		if e.receiver != implicitSelf && e.delegate == "" {
+			return spanStart(e.receiver)
+		}
+	case *keyword:
+		if e.receiver != implicitSelf && e.delegate == "" {
+			return spanStart(e.receiver)
+		}
+	case *sequence:
+		if len(e.exprs) > 0 && e.exprs[0] != nil {
+			return spanStart(e.exprs[0])
+		}
+	}
+	return e.Pos()
+}
+
+// Message sends record pos, the byte offset of their delegate or, if there
+// is none, of their (first) selector.
 
 type keyword struct {
 	receiver  expr
 	keywords  []string
 	arguments []expr
 	delegate  string
+	pos       int
 }
 
+func (e *keyword) Pos() int { return e.pos }
+
+func (e *keyword) End() int {
+	for i := len(e.arguments) - 1; i >= 0; i-- {
+		if e.arguments[i] != nil {
+			return e.arguments[i].End()
+		}
+	}
+	return selectorEnd(e.pos, e.delegate, e.keywords[0])
+}
+
+type unary struct {
+	receiver expr
+	selector string
+	delegate string
+	pos      int
+}
+
+func (e *unary) Pos() int { return e.pos }
+
+func (e *unary) End() int { return selectorEnd(e.pos, e.delegate, e.selector) }
+
 type binary struct {
 	receiver expr
 	operator string
 	argument expr
 	delegate string
+	pos      int
+}
+
+func (e *binary) Pos() int { return e.pos }
+
+func (e *binary) End() int {
+	if e.argument != nil {
+		return e.argument.End()
+	}
+	return selectorEnd(e.pos, e.delegate, e.operator)
+}
+
+// selectorEnd returns the offset just past selector, sent from pos to the
+// given delegate, which the selector follows directly, as in "parent.foo".
+func selectorEnd(pos int, delegate, selector string) int {
+	if delegate != "" {
+		pos += len(delegate) + len(".")
+	}
+	return pos + len(selector)
+}
+
+// A selfExpr is an explicit reference to self.
+type selfExpr struct {
+	pos int
+}
+
+func (e *selfExpr) Pos() int { return e.pos }
+func (e *selfExpr) End() int { return e.pos + len("self") }
+
+// A numberLit is a number literal, such as "16rFF".
+type numberLit struct {
+	text string // source text, exactly as written
+	pos  int
+}
+
+func (e *numberLit) Pos() int { return e.pos }
+func (e *numberLit) End() int { return e.pos + len(e.text) }
+
+// isInt reports whether e is an integer rather than a real, which has a
+// fraction or an exponent.
+func (e *numberLit) isInt() bool {
+	return strings.ContainsAny(e.text, "rR") || !strings.ContainsAny(e.text, ".eE")
+}
+
+// int returns the value of e, which must be an integer.
+func (e *numberLit) int() (*big.Int, error) {
+	digits, base := e.text, 10
+	if i := strings.IndexAny(digits, "rR"); i >= 0 {
+		base, _ = strconv.Atoi(strings.TrimPrefix(digits[:i], "-"))
+		if digits = digits[i+1:]; e.text[0] == '-' {
+			digits = "-" + digits
+		}
+	}
+	n, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %q", e.text)
+	}
+	return n, nil
+}
+
+// float returns the value of e as a float64. The sign of a zero is kept, so
+// "-0.0" is negative zero.
+func (e *numberLit) float() (float64, error) {
+	if !strings.ContainsAny(e.text, "rR") {
+		return strconv.ParseFloat(e.text, 64)
+	}
+	n, err := e.int()
+	if err != nil {
+		return 0, err
+	}
+	f, _ := new(big.Float).SetInt(n).Float64()
+	if e.text[0] == '-' && f == 0 {
+		f = math.Copysign(0, -1)
+	}
+	return f, nil
+}
+
+// exact reports whether the value of the real e is exactly a float64.
+func (e *numberLit) exact() bool {
+	f, err := e.float()
+	if err != nil {
+		return false
+	}
+	if f == 0 {
+		// Avoid building huge rationals for underflowing exponents: a zero
+		// is exact only if all its digits are.
+		mantissa := strings.TrimLeft(e.text, "-")
+		if i := strings.IndexAny(mantissa, "eE"); i >= 0 {
+			mantissa = mantissa[:i]
+		}
+		return strings.Trim(mantissa, "0.") == ""
+	}
+	r, ok := new(big.Rat).SetString(e.text)
+	return ok && r.Cmp(new(big.Rat).SetFloat64(f)) == 0
+}
+
+// A stringLit is a string literal, such as 'hi'.
+type stringLit struct {
+	value string // characters denoted by the literal, with escapes replaced
+	pos   int
+	end   int // offset just past the closing quote
+}
+
+func (e *stringLit) Pos() int { return e.pos }
+func (e *stringLit) End() int { return e.end }
+
+// A sequence is a list of statements separated by periods.
+type sequence struct {
+	exprs   []expr
+	doc     string // text of a program's leading comments, in ParseComments mode
+	trailer string // text of the comments after its last statement, likewise
+}
+
+// Pos returns the position of the first statement of s, or 0 if there is
+// none.
+func (s *sequence) Pos() int {
+	if len(s.exprs) == 0 || s.exprs[0] == nil {
+		return 0
+	}
+	return s.exprs[0].Pos()
+}
+
+// End returns the end of the last statement of s, or 0 if there is none.
+func (s *sequence) End() int {
+	for i := len(s.exprs) - 1; i >= 0; i-- {
+		if s.exprs[i] != nil {
+			return s.exprs[i].End()
+		}
+	}
+	return 0
+}
+
+// A placeholder is a lone '_' standing for a value that does not matter,
+// parsed only in Placeholders mode.
+type placeholder struct {
+	pos int
+}
+
+func (e *placeholder) Pos() int { return e.pos }
+func (e *placeholder) End() int { return e.pos + len("_") }
+
+// A returnStmt is a statement returning the value of an expression, "^expr".
+type returnStmt struct {
+	value expr
+	pos   int
+}
+
+func (e *returnStmt) Pos() int { return e.pos }
+
+func (e *returnStmt) End() int {
+	if e.value != nil {
+		return e.value.End()
+	}
+	return e.pos + len("^")
+}
+
+// An object is an object literal, "(| slots | statements)". Parenthesized
+// expressions are objects without slots.
+type object struct {
+	slots []*slot
+	body  []expr
+	pos   int
+	end   int // offset just past the closing parenthesis
+}
+
+func (e *object) Pos() int { return e.pos }
+func (e *object) End() int { return e.end }
+
+// A block is a block literal, "[:arg | | slots | statements]", whose
+// arguments are argument slots. Self's form, "[| :arg. slots | statements]",
+// is also accepted.
+type block struct {
+	slots []*slot
+	body  []expr
+	pos   int
+	end   int // offset just past the closing bracket
+}
+
+func (e *block) Pos() int { return e.pos }
+func (e *block) End() int { return e.end }
+
+type slotKind int
+
+const (
+	dataSlot       slotKind = iota // name = value
+	assignableSlot                 // name <- value, or just name
+	argumentSlot                   // :name
+)
+
+type slot struct {
+	name   string   // slot name, or method selector such as "at:Put:"
+	kind   slotKind // kind of slot
+	parent bool     // whether the slot is a parent slot, "name* = value"
+	params []string // argument names of a binary or keyword method slot
+	value  expr     // initializer, or nil if there is none
+	pos    int
+}
+
+// BuildKeyword returns a keyword message sending selector, such as "at:Put:",
+// to receiver with the given arguments. The number of arguments must match
+// the number of keyword parts in selector. Each part is an identifier
+// followed by ':', and every part after the first must be capitalized, so
+// that the message parses back as a single send.
+func BuildKeyword(selector string, receiver expr, args ...expr) (expr, error) {
+	if !strings.HasSuffix(selector, ":") {
+		return nil, fmt.Errorf("invalid keyword selector %q", selector)
+	}
+	parts := strings.SplitAfter(selector, ":")
+	parts = parts[:len(parts)-1] // drop the empty string after the final ':'
+	for i, kw := range parts {
+		first := identifierStart
+		if i > 0 {
+			first = capitalLetter
+		}
+		name := kw[:len(kw)-1]
+		if name == "" || !strings.ContainsRune(first, rune(name[0])) || strings.Trim(name[1:], identifierChars) != "" {
+			return nil, fmt.Errorf("invalid keyword selector %q", selector)
+		}
+	}
+	if len(parts) != len(args) {
+		return nil, fmt.Errorf("selector %q takes %d arguments, found %d", selector, len(parts), len(args))
+	}
+	return &keyword{receiver, parts, args, "", 0}, nil
 }
@@ -0,0 +1,205 @@
+package ego
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildKeyword(t *testing.T) {
+	recv, a, b := &binary{operator: "+"}, &binary{operator: "-"}, &binary{operator: "*"}
+	e, err := BuildKeyword("at:Put:", recv, a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &keyword{recv, []string{"at:", "Put:"}, []expr{a, b}, "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+
+	bad := []struct {
+		selector string
+		args     []expr
+	}{
+		{"at:Put:", []expr{a}},
+		{"at:", []expr{a, b}},
+		{"at:", nil},
+		{"at", []expr{a}},
+		{"at::", []expr{a, b}},
+		{"", nil},
+		{"1:", []expr{a}},
+		{"a b:", []expr{a}},
+		{"at:put:", []expr{a, b}},
+		{"At:Put:", []expr{a, b}},
+		{"at:P-t:", []expr{a, b}},
+		{"at:é:", []expr{a, b}},
+	}
+	for _, selector := range []string{"x:", "_at:", "at1:Put_2:With:"} {
+		args := make([]expr, strings.Count(selector, ":"))
+		if _, err := BuildKeyword(selector, recv, args...); err != nil {
+			t.Errorf("%q: unexpected error: %v", selector, err)
+		}
+	}
+	for i, test := range bad {
+		if e, err := BuildKeyword(test.selector, recv, test.args...); err == nil {
+			t.Errorf("[%d] expected error for %q with %d arguments but found %#v", i, test.selector, len(test.args), e)
+		}
+	}
+}
+
+func TestUnaryNode(t *testing.T) {
+	n, errs := Parse("test", "anObject doSomething")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	receiver := &unary{nil, "anObject", "", 0}
+	expected := &unary{receiver, "doSomething", "", 9}
+	if s := n.(*sequence); len(s.exprs) != 1 || !reflect.DeepEqual(s.exprs[0], expected) {
+		t.Fatalf("expected %#v but found %#v", expected, s.exprs)
+	}
+	if pos := expected.Pos(); pos != 9 {
+		t.Errorf("expected position 9 but found %d", pos)
+	}
+
+	var visited []expr
+	Walk(n, func(e expr) bool {
+		visited = append(visited, e)
+		return true
+	})
+	if len(visited) != 3 || visited[1] != n.(*sequence).exprs[0] || visited[2] != n.(*sequence).exprs[0].(*unary).receiver {
+		t.Errorf("expected Walk to visit the sequence, send and receiver but found %#v", visited)
+	}
+}
+
+func TestBinaryPositions(t *testing.T) {
+	source := "a foo + 12 bar"
+	n, errs := Parse("test", source)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	b, ok := n.(*sequence).exprs[0].(*binary)
+	if !ok {
+		t.Fatalf("expected a binary send but found %#v", n.(*sequence).exprs[0])
+	}
+	// A send is positioned at its selector, a literal at its start.
+	for _, test := range []struct {
+		node Node
+		text string
+	}{
+		{b, "+ 12 bar"},
+		{b.receiver, "foo + 12 bar"},
+		{b.receiver.(*unary).receiver, source},
+		{b.argument, "bar"},
+		{b.argument.(*unary).receiver, "12 bar"},
+	} {
+		if text := source[test.node.Pos():]; text != test.text {
+			t.Errorf("%#v: expected source from %q but found %q", test.node, test.text, text)
+		}
+	}
+}
+
+func TestSpan(t *testing.T) {
+	tests := []struct {
+		source string
+		span   string
+	}{
+		{"x. a foo + 12 bar. y", "a foo + 12 bar"},
+		{"x. a foo at: 1 + 2 Put: 'b\\'c'. y", "a foo at: 1 + 2 Put: 'b\\'c'"},
+		{"x. at: (3) Put: [4]. y", "at: (3) Put: [4]"},
+		{"x. resend.foo: self. y", "resend.foo: self"},
+		{"x. parent.bar. y", "parent.bar"},
+		{"x. ^ -1 abs. y", "^ -1 abs"},
+		{"x. (a) b - -3. y", "(a) b - -3"},
+	}
+	for _, test := range tests {
+		n, errs := Parse("test", test.source)
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors %v", test.source, errs)
+			continue
+		}
+		start, end := Span(n.(*sequence).exprs[1])
+		if span := test.source[start:end]; span != test.span {
+			t.Errorf("%q: expected span %q but found %q", test.source, test.span, span)
+		}
+	}
+
+	// A send missing its argument ends with its selector.
+	n, _ := Parse("test", "a foo: ")
+	if start, end := Span(n); start != 0 || end != 6 {
+		t.Errorf("expected span 0..6 but found %d..%d", start, end)
+	}
+}
+
+func TestNumberFloat(t *testing.T) {
+	tests := []struct {
+		text    string
+		value   float64
+		signbit bool
+	}{
+		{"0.0", 0, false},
+		{"-0.0", 0, true},
+		{"0", 0, false},
+		{"-0", 0, true},
+		{"-0e5", 0, true},
+		{"3.25", 3.25, false},
+		{"-1.5e3", -1500, true},
+		{"16rFF", 255, false},
+		{"-2r101", -5, true},
+		{"-16r0", 0, true},
+	}
+	for _, test := range tests {
+		n, errs := Parse("test", test.text)
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors %v", test.text, errs)
+			continue
+		}
+		f, err := n.(*sequence).exprs[0].(*numberLit).float()
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.text, err)
+		}
+		if f != test.value || math.Signbit(f) != test.signbit {
+			t.Errorf("%q: expected %v (sign bit %t) but found %v (sign bit %t)", test.text, test.value, test.signbit, f, math.Signbit(f))
+		}
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	statement := func(source string) expr {
+		n, errs := Parse("test", source)
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors %v", source, errs)
+			return nil
+		}
+		return n.(*sequence).exprs[0]
+	}
+
+	if e := statement("'hi'"); !reflect.DeepEqual(e, &stringLit{"hi", 0, 4}) {
+		t.Errorf("expected the string 'hi' but found %#v", e)
+	}
+	if e := statement(`x: 'a\'b\n'`); !reflect.DeepEqual(e, &keyword{nil, []string{"x:"}, []expr{&stringLit{"a'b\n", 3, 11}}, "", 0}) {
+		t.Errorf("unexpected keyword message %#v", e)
+	}
+
+	ints := []struct {
+		text  string
+		value int64
+	}{
+		{"42", 42}, {"-17", -17}, {"16rFF", 255}, {"-2r101", -5}, {"36rz", 35}, {"0", 0},
+	}
+	for _, test := range ints {
+		n, ok := statement(test.text).(*numberLit)
+		if !ok || !n.isInt() {
+			t.Errorf("%q: expected an integer but found %#v", test.text, n)
+			continue
+		}
+		if v, err := n.int(); err != nil || v.Int64() != test.value {
+			t.Errorf("%q: expected %d but found %v (%v)", test.text, test.value, v, err)
+		}
+	}
+	for _, text := range []string{"4.2", "1e3", "-2.5E-3"} {
+		if n, ok := statement(text).(*numberLit); !ok || n.isInt() {
+			t.Errorf("%q: expected a real but found %#v", text, n)
+		}
+	}
+}
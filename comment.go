@@ -0,0 +1,29 @@
+package ego
+
+// A CommentSpan is a comment in the source.
+type CommentSpan struct {
+	Pos, End int    // byte offsets of the opening quote and just past the closing one
+	Text     string // text of the comment, without its delimiters
+}
+
+// Comments lexes input and returns its comments in source order. Self
+// comments are delimited by double quotes and neither nest nor have escapes:
+// the first '"' after the opening one closes a comment. Lexing stops at the
+// first error, so an unclosed comment is not returned.
+func Comments(name, input string) (spans []CommentSpan) {
+	for i := range lex(name, input, Config{Mode: ParseComments}) {
+		if i.t == TokenComment {
+			spans = append(spans, CommentSpan{i.pos, i.pos + len(i.v), commentText(i.v)})
+		}
+	}
+	return
+}
+
+// commentText returns the text of a comment lexed by lexComment or
+// lexLineComment, without its delimiters.
+func commentText(comment string) string {
+	if comment[0] == '#' {
+		return comment[1:]
+	}
+	return comment[1 : len(comment)-1]
+}
@@ -0,0 +1,23 @@
+package ego
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComments(t *testing.T) {
+	source := `"header" x <- 3. "about y"
+y: 'not "a comment"' ""
+"unclosed`
+	expected := []CommentSpan{
+		{0, 8, "header"},
+		{17, 26, "about y"},
+		{48, 50, ""},
+	}
+	if spans := Comments("test", source); !reflect.DeepEqual(spans, expected) {
+		t.Errorf("expected %v but found %v", expected, spans)
+	}
+	if spans := Comments("test", "a b"); spans != nil {
+		t.Errorf("expected no comments but found %v", spans)
+	}
+}
@@ -0,0 +1,64 @@
+package ego
+
+import (
+	varint "encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Encoded lexemes start with lexemesMagic followed by the version of the
+// encoding. Each lexeme is then encoded as unsigned varints giving its type,
+// position, length of source text, line, column and the length of its text,
+// followed by the text.
+const (
+	lexemesMagic   = "ego"
+	lexemesVersion = 2
+)
+
+// EncodeLexemes returns a compact binary encoding of lexemes, for caching
+// the result of lexing a file that has not changed. DecodeLexemes reverses
+// it.
+func EncodeLexemes(lexemes []Lexeme) []byte {
+	b := append([]byte(lexemesMagic), lexemesVersion)
+	for _, l := range lexemes {
+		b = varint.AppendUvarint(b, uint64(l.Type))
+		b = varint.AppendUvarint(b, uint64(l.Pos))
+		b = varint.AppendUvarint(b, uint64(l.End-l.Pos))
+		b = varint.AppendUvarint(b, uint64(l.Line))
+		b = varint.AppendUvarint(b, uint64(l.Column))
+		b = varint.AppendUvarint(b, uint64(len(l.Text)))
+		b = append(b, l.Text...)
+	}
+	return b
+}
+
+// DecodeLexemes returns the lexemes encoded in b by EncodeLexemes. It
+// reports an error if b is not an encoding of lexemes or was written by an
+// unknown version of the encoding.
+func DecodeLexemes(b []byte) ([]Lexeme, error) {
+	if len(b) < len(lexemesMagic)+1 || string(b[:len(lexemesMagic)]) != lexemesMagic {
+		return nil, errors.New("not an encoding of lexemes")
+	}
+	if v := b[len(lexemesMagic)]; v != lexemesVersion {
+		return nil, fmt.Errorf("unsupported lexeme encoding version %d", v)
+	}
+	b = b[len(lexemesMagic)+1:]
+	var lexemes []Lexeme
+	for len(b) > 0 {
+		var fields [6]uint64
+		for f := range fields {
+			n, w := varint.Uvarint(b)
+			if w <= 0 {
+				return nil, errors.New("truncated lexeme encoding")
+			}
+			fields[f], b = n, b[w:]
+		}
+		if fields[0] >= uint64(len(tokens)) || fields[5] > uint64(len(b)) {
+			return nil, errors.New("invalid lexeme encoding")
+		}
+		pos := int(fields[1])
+		lexemes = append(lexemes, Lexeme{Token(fields[0]), string(b[:fields[5]]), pos, pos + int(fields[2]), int(fields[3]), int(fields[4])})
+		b = b[fields[5]:]
+	}
+	return lexemes, nil
+}
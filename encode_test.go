@@ -0,0 +1,46 @@
+package ego
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeLexemes(t *testing.T) {
+	for _, source := range []string{
+		"",
+		"foo bar: 3 Baz: 'qu\\tux'. ^resend.+ x",
+		"(| p* = q. + a = (a) |)\n\t[:é | é]",
+		"'unclosed",
+	} {
+		var lexemes []Lexeme
+		next := Lex("test", source)
+		for l, ok := next(); ok; l, ok = next() {
+			lexemes = append(lexemes, l)
+		}
+		decoded, err := DecodeLexemes(EncodeLexemes(lexemes))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", source, err)
+		}
+		if !reflect.DeepEqual(decoded, lexemes) {
+			t.Errorf("%q: expected %v but found %v", source, lexemes, decoded)
+		}
+	}
+}
+
+func TestDecodeLexemesErrors(t *testing.T) {
+	valid := EncodeLexemes([]Lexeme{{TokenIdentifier, "foo", 0, 3, 1, 1}})
+	for _, b := range [][]byte{
+		nil,
+		[]byte("ego"),
+		[]byte("abc\x01"),
+		[]byte("ego\x01"),
+		[]byte("ego\x03"),
+		valid[:len(valid)-1],
+		valid[:len(valid)-4],
+		[]byte("ego\x02\x7f\x00\x00\x01\x01\x00"),
+	} {
+		if _, err := DecodeLexemes(b); err == nil {
+			t.Errorf("%q: expected an error", b)
+		}
+	}
+}
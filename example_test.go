@@ -0,0 +1,43 @@
+package ego_test
+
+import (
+	"fmt"
+
+	"github.com/fbogsany/ego"
+)
+
+func ExampleParse() {
+	node, errs := ego.Parse("example", "dict at: 1 Put: 2")
+	if len(errs) != 0 {
+		fmt.Println(errs)
+		return
+	}
+	ego.Walk(node, func(n ego.Node) bool {
+		if s := ego.Selector(n); s != "" {
+			fmt.Println(s, "at", n.Pos())
+		}
+		return true
+	})
+	// Output:
+	// at:Put: at 5
+	// dict at 0
+}
+
+func ExampleLex() {
+	next := ego.Lex("example", "(| x <- 'a' |) x + -3")
+	for l, ok := next(); ok; l, ok = next() {
+		fmt.Printf("%s %q\n", l.Type, l.Text)
+	}
+	// Output:
+	// ( "("
+	// | "|"
+	// identifier "x"
+	// <- "<-"
+	// string "a"
+	// | "|"
+	// ) ")"
+	// identifier "x"
+	// operator "+"
+	// number "-3"
+	// EOF ""
+}
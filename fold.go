@@ -0,0 +1,146 @@
+package ego
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Fold replaces constant arithmetic in the tree rooted at e, binary sends of
+// '+', '-', '*' or '/' whose operands are (parenthesized) number literals,
+// with the literal they evaluate to, and returns the resulting tree. The tree
+// is modified in place; only a folded root is replaced. Integer operands are
+// promoted to reals when the other operand is a real. Integer division,
+// division by zero and results that are not finite are left unfolded, as are
+// resends.
+func Fold(e expr) expr {
+	switch e := e.(type) {
+	case *unary:
+		e.receiver = Fold(e.receiver)
+	case *binary:
+		e.receiver = Fold(e.receiver)
+		e.argument = Fold(e.argument)
+		if n := foldBinary(e); n != nil {
+			return n
+		}
+	case *keyword:
+		e.receiver = Fold(e.receiver)
+		for i, arg := range e.arguments {
+			e.arguments[i] = Fold(arg)
+		}
+	case *returnStmt:
+		e.value = Fold(e.value)
+	case *sequence:
+		for i, s := range e.exprs {
+			e.exprs[i] = Fold(s)
+		}
+	case *object:
+		foldSlots(e.slots)
+		for i, s := range e.body {
+			e.body[i] = Fold(s)
+		}
+	case *block:
+		foldSlots(e.slots)
+		for i, s := range e.body {
+			e.body[i] = Fold(s)
+		}
+	}
+	return e
+}
+
+func foldSlots(slots []*slot) {
+	for _, s := range slots {
+		if s.value != nil {
+			s.value = Fold(s.value)
+		}
+	}
+}
+
+// foldBinary returns the literal that e evaluates to, positioned at its
+// receiver, or nil if e cannot be folded.
+func foldBinary(e *binary) *numberLit {
+	x, y := constant(e.receiver), constant(e.argument)
+	if x == nil || y == nil || e.delegate != "" {
+		return nil
+	}
+	switch e.operator {
+	case "+", "-", "*", "/":
+	default:
+		return nil
+	}
+	if x.isInt() && y.isInt() {
+		if e.operator == "/" {
+			return nil
+		}
+		a, err := x.int()
+		if err != nil {
+			return nil
+		}
+		b, err := y.int()
+		if err != nil {
+			return nil
+		}
+		switch e.operator {
+		case "+":
+			a.Add(a, b)
+		case "-":
+			a.Sub(a, b)
+		case "*":
+			a.Mul(a, b)
+		}
+		return &numberLit{a.String(), e.receiver.Pos()}
+	}
+	a, err := x.float()
+	if err != nil {
+		return nil
+	}
+	b, err := y.float()
+	if err != nil {
+		return nil
+	}
+	var f float64
+	switch e.operator {
+	case "+":
+		f = a + b
+	case "-":
+		f = a - b
+	case "*":
+		f = a * b
+	case "/":
+		if b == 0 {
+			return nil
+		}
+		f = a / b
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil
+	}
+	return &numberLit{realText(f), e.receiver.Pos()}
+}
+
+// constant returns the number literal e consists of, looking through
+// parentheses, or nil if there is none.
+func constant(e expr) *numberLit {
+	for {
+		switch n := e.(type) {
+		case *numberLit:
+			return n
+		case *object:
+			if len(n.slots) != 0 || len(n.body) != 1 {
+				return nil
+			}
+			e = n.body[0]
+		default:
+			return nil
+		}
+	}
+}
+
+// realText returns the shortest text denoting the real f.
+func realText(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,45 @@
+package ego
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"2 + 3", &numberLit{"5", 0}},
+		{"2 + (3 * 4)", &numberLit{"14", 0}},
+		{"(2 + 3) * 4", &numberLit{"20", 0}},
+		{"2 + 3 + 4", &numberLit{"9", 0}},
+		{"(2 + 3 + 4) - 10", &numberLit{"-1", 0}},
+		{"((1.5)) - (-2)", &numberLit{"3.5", 0}},
+		{"(| x | 2) + 3", &binary{&object{[]*slot{{"x", assignableSlot, false, nil, nil, 0}}, []expr{&numberLit{"2", 0}}, 0, 0}, "+", &numberLit{"3", 0}, "", 0}},
+		{"16rFF * 2", &numberLit{"510", 0}},
+		{"1.5 * 2", &numberLit{"3.0", 0}},
+		{"1 / 4.0", &numberLit{"0.25", 0}},
+		{"a + 3", &binary{&unary{implicitSelf, "a", "", 0}, "+", &numberLit{"3", 0}, "", 0}},
+		{"7 / 2", &binary{&numberLit{"7", 0}, "/", &numberLit{"2", 0}, "", 0}},
+		{"1.0 / 0", &binary{&numberLit{"1.0", 0}, "/", &numberLit{"0", 0}, "", 0}},
+		{"2 max: 3 + 4", &keyword{&numberLit{"2", 0}, []string{"max:"}, []expr{&numberLit{"7", 0}}, "", 0}},
+		{"resend.+ 3", &binary{implicitSelf, "+", &numberLit{"3", 0}, "resend", 0}},
+		{"2 < 3", &binary{&numberLit{"2", 0}, "<", &numberLit{"3", 0}, "", 0}},
+	}
+	for _, test := range tests {
+		if e := Fold(parseExpr(t, test.source)); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %v but found %v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestFoldPos(t *testing.T) {
+	source := "(| x = (2 + 3) * 4 |)"
+	p := parse("test", source, Config{})
+	defer p.close()
+	o := Fold(p.parseExpr()).(*object)
+	if n, ok := o.slots[0].value.(*numberLit); !ok || *n != (numberLit{"20", 7}) {
+		t.Errorf("expected 20 at 7 but found %#v", o.slots[0].value)
+	}
+}
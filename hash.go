@@ -0,0 +1,124 @@
+package ego
+
+import (
+	varint "encoding/binary"
+	"hash"
+	"hash/fnv"
+)
+
+// Hash returns a structural hash of the tree rooted at e: trees that differ
+// only in positions and comments hash equally, while trees that differ
+// otherwise usually do not.
+func Hash(e expr) uint64 {
+	h := hasher{fnv.New64a()}
+	h.expr(e)
+	return h.Sum64()
+}
+
+// A hasher feeds a tree to a hash in prefix order. Every node writes a tag
+// for its kind and every list its length, so that distinct trees do not
+// produce the same input.
+type hasher struct{ hash.Hash64 }
+
+const (
+	hashNil byte = iota
+	hashUnary
+	hashBinary
+	hashKeyword
+	hashSelf
+	hashNumber
+	hashPlaceholder
+	hashReturn
+	hashSequence
+	hashObject
+	hashBlock
+	hashString
+)
+
+func (h hasher) int(n int) {
+	var b [varint.MaxVarintLen64]byte
+	h.Write(b[:varint.PutVarint(b[:], int64(n))])
+}
+
+func (h hasher) string(s string) {
+	h.int(len(s))
+	h.Write([]byte(s))
+}
+
+func (h hasher) tag(t byte) { h.Write([]byte{t}) }
+
+func (h hasher) exprs(list []expr) {
+	h.int(len(list))
+	for _, e := range list {
+		h.expr(e)
+	}
+}
+
+func (h hasher) slots(slots []*slot) {
+	h.int(len(slots))
+	for _, s := range slots {
+		h.string(s.name)
+		h.int(int(s.kind))
+		if s.parent {
+			h.int(1)
+		} else {
+			h.int(0)
+		}
+		h.int(len(s.params))
+		for _, p := range s.params {
+			h.string(p)
+		}
+		h.expr(s.value)
+	}
+}
+
+func (h hasher) expr(e expr) {
+	switch e := e.(type) {
+	case *unary:
+		h.tag(hashUnary)
+		h.string(e.delegate)
+		h.string(e.selector)
+		h.expr(e.receiver)
+	case *binary:
+		h.tag(hashBinary)
+		h.string(e.delegate)
+		h.string(e.operator)
+		h.expr(e.receiver)
+		h.expr(e.argument)
+	case *keyword:
+		h.tag(hashKeyword)
+		h.string(e.delegate)
+		h.int(len(e.keywords))
+		for _, k := range e.keywords {
+			h.string(k)
+		}
+		h.expr(e.receiver)
+		h.exprs(e.arguments)
+	case *selfExpr:
+		h.tag(hashSelf)
+	case *numberLit:
+		h.tag(hashNumber)
+		h.string(e.text)
+	case *stringLit:
+		h.tag(hashString)
+		h.string(e.value)
+	case *placeholder:
+		h.tag(hashPlaceholder)
+	case *returnStmt:
+		h.tag(hashReturn)
+		h.expr(e.value)
+	case *sequence:
+		h.tag(hashSequence)
+		h.exprs(e.exprs)
+	case *object:
+		h.tag(hashObject)
+		h.slots(e.slots)
+		h.exprs(e.body)
+	case *block:
+		h.tag(hashBlock)
+		h.slots(e.slots)
+		h.exprs(e.body)
+	default:
+		h.tag(hashNil)
+	}
+}
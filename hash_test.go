@@ -0,0 +1,42 @@
+package ego
+
+import "testing"
+
+func TestHash(t *testing.T) {
+	program := func(source string) expr {
+		p := parse("test", source, Config{Mode: ParseComments})
+		defer p.close()
+		e := p.parseProgram()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", source, err)
+		}
+		return e
+	}
+	equal := [][2]string{
+		{"a foo: b Bar: 3", "a   foo:  b\n\tBar: 3"},
+		{"(| x = 3. + y = (x + y) | ^x)", `"doc" (|x=3.+ y=(x+y)| ^x)`},
+		{"[:x | x foo]. resend.bar", "[ :x|x foo ].resend.bar"},
+		{"", `"only a comment"`},
+	}
+	for _, test := range equal {
+		if h0, h1 := Hash(program(test[0])), Hash(program(test[1])); h0 != h1 {
+			t.Errorf("%q and %q: expected equal hashes but found %x and %x", test[0], test[1], h0, h1)
+		}
+	}
+
+	distinct := []string{
+		"", "a", "b", "a b", "b a", "a foo", "self foo", "resend.foo", "p.foo",
+		"a + b", "a - b", "b + a", "a at: b", "a at: b Put: c", "a at: (b put: c)",
+		"3", "4", "3 foo", "^3", "(3)", "[3]", "[:x | 3]", "[| x | 3]", "(| x = 3 |)",
+		"(| x <- 3 |)", "(| x* = 3 |)", "(| x |)", "(| + x = (3) |)", "(| + y = (3) |)",
+		"a. b", "a b. c", "a. b c", "'a'", "'b'", "''",
+	}
+	seen := make(map[uint64]string)
+	for _, source := range distinct {
+		h := Hash(program(source))
+		if other, ok := seen[h]; ok {
+			t.Errorf("%q and %q: expected distinct hashes but both are %x", other, source, h)
+		}
+		seen[h] = source
+	}
+}
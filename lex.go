@@ -1,87 +1,112 @@
 package ego
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
-// token identifies the type of lex tokens.
-type token int
+// A Token identifies the type of a lexical token.
+type Token int
 
 const (
-	tokenError        token = iota // error occurred; value is text of error
-	tokenEOF                       // end of input
+	TokenError        Token = iota // error occurred; value is text of error
+	TokenEOF                       // end of input
+	TokenWarning                   // suspicious input; value is text of warning
 	literals_start                 // start of tokens with meaningful values
-	tokenIdentifier                // identifier
-	tokenSmallKeyword              // small keyword
-	tokenCapKeyword                // capitalized keyword
-	tokenArgumentName              // argument name
-	tokenOperator                  // operator
-	tokenNumber                    // numeric constant
-	tokenString                    // string constant
-	tokenDelegate                  // identifier '.'
+	TokenIdentifier                // identifier
+	TokenSmallKeyword              // small keyword
+	TokenCapKeyword                // capitalized keyword
+	TokenArgumentName              // argument name
+	TokenOperator                  // operator
+	TokenNumber                    // numeric constant
+	TokenString                    // string constant
+	TokenComment                   // comment, when scanning comments
+	TokenDelegate                  // identifier '.'
 	literals_end                   // end of tokens with meaningful values
-	tokenResend                    // 'resend.'
-	tokenSelf                      // 'self'
-	tokenLeftParen                 // '('
-	tokenLeftBracket               // '['
-	tokenLeftBrace                 // '{'
-	tokenRightParen                // ')'
-	tokenRightBracket              // ']'
-	tokenRightBrace                // '}'
-	tokenBar                       // '|'
-	tokenPeriod                    // '.'
-	tokenCaret                     // '^'
-	tokenLeftArrow                 // '<-'
-	tokenEqual                     // '='
-	tokenStar                      // '*'
+	TokenResend                    // 'resend.'
+	TokenSelf                      // 'self'
+	TokenLeftParen                 // '('
+	TokenLeftBracket               // '['
+	TokenLeftBrace                 // '{'
+	TokenRightParen                // ')'
+	TokenRightBracket              // ']'
+	TokenRightBrace                // '}'
+	TokenBar                       // '|'
+	TokenPeriod                    // '.'
+	TokenCaret                     // '^'
+	TokenLeftArrow                 // '<-'
+	TokenEqual                     // '='
+	TokenStar                      // '*'
+	TokenPlaceholder               // '_', when scanning placeholders
+	TokenIndent                    // deeper indentation, when scanning indentation
+	TokenDedent                    // shallower indentation, when scanning indentation
 )
 
 var tokens = [...]string{
-	tokenError:        "error",
-	tokenEOF:          "EOF",
-	tokenIdentifier:   "identifier",
-	tokenSmallKeyword: "small-keyword",
-	tokenCapKeyword:   "capitalized-keyword",
-	tokenArgumentName: "argument-name",
-	tokenOperator:     "operator",
-	tokenNumber:       "number",
-	tokenString:       "string",
-	tokenDelegate:     "delegate",
-	tokenResend:       "resend",
-	tokenSelf:         "self",
-	tokenLeftParen:    "(",
-	tokenLeftBracket:  "[",
-	tokenLeftBrace:    "{",
-	tokenRightParen:   ")",
-	tokenRightBracket: "]",
-	tokenRightBrace:   "}",
-	tokenBar:          "|",
-	tokenPeriod:       ".",
-	tokenCaret:        "^",
-	tokenLeftArrow:    "<-",
-	tokenEqual:        "=",
-	tokenStar:         "*",
-}
-
-func (t token) isLiteral() bool { return literals_start < t && t < literals_end }
+	TokenError:        "error",
+	TokenEOF:          "EOF",
+	TokenWarning:      "warning",
+	TokenIdentifier:   "identifier",
+	TokenSmallKeyword: "small-keyword",
+	TokenCapKeyword:   "capitalized-keyword",
+	TokenArgumentName: "argument-name",
+	TokenOperator:     "operator",
+	TokenNumber:       "number",
+	TokenString:       "string",
+	TokenComment:      "comment",
+	TokenDelegate:     "delegate",
+	TokenResend:       "resend",
+	TokenSelf:         "self",
+	TokenLeftParen:    "(",
+	TokenLeftBracket:  "[",
+	TokenLeftBrace:    "{",
+	TokenRightParen:   ")",
+	TokenRightBracket: "]",
+	TokenRightBrace:   "}",
+	TokenBar:          "|",
+	TokenPeriod:       ".",
+	TokenCaret:        "^",
+	TokenLeftArrow:    "<-",
+	TokenEqual:        "=",
+	TokenStar:         "*",
+	TokenPlaceholder:  "_",
+	TokenIndent:       "indent",
+	TokenDedent:       "dedent",
+}
+
+// String returns the name of t, such as "identifier" or "(".
+func (t Token) String() string {
+	if t < 0 || int(t) >= len(tokens) || tokens[t] == "" {
+		return fmt.Sprintf("Token(%d)", int(t))
+	}
+	return tokens[t]
+}
+
+func (t Token) isLiteral() bool { return literals_start < t && t < literals_end }
 
 const eof = 0
 
 // item represents a token returned from the scanner.
 type item struct {
-	t   token  // Type, such as tokenNumber.
-	v   string // Value, such as "23.2".
-	pos int
+	t    Token  // Type, such as TokenNumber.
+	v    string // Value, such as "23.2".
+	pos  int    // Byte offset in the input.
+	line int    // Line number, starting at 1.
+	col  int    // Column number, starting at 1.
 }
 
 func (i item) String() string {
 	switch i.t {
-	case tokenEOF:
+	case TokenEOF:
 		return "EOF"
-	case tokenError:
+	case TokenIndent, TokenDedent:
+		return tokens[i.t]
+	case TokenError, TokenWarning:
 		return i.v
 	}
 	if len(i.v) > 10 {
@@ -92,21 +117,58 @@ func (i item) String() string {
 
 // lexer holds the state of the scanner.
 type lexer struct {
-	name  string      // Used only for error reports.
-	input string      // The string being scanned.
-	start int         // Start position of this item.
-	pos   int         // Current position in the input.
-	width int         // Width of last rune read from input.
-	items chan<- item // Channel of scanned items.
+	name     string // Used only for error reports.
+	input    string // The string being scanned.
+	start    int    // Start position of this item.
+	pos      int    // Current position in the input.
+	width    int    // Width of last rune read from input.
+	mode     Mode   // Optional lexer functionality.
+	tabWidth int    // Columns between tab stops, if positive.
+	line     int    // Line number at lpos.
+	col      int    // Column number at lpos.
+	lpos     int    // Position of the last computed line and column.
+	offset   int    // Offset of the input in a host file.
+	items    []item // Items scanned but not yet returned.
+	bol      bool   // Whether no token has started on this line yet.
+	indents  []int  // Open indentation levels, in IndentTokens mode.
 }
 
 type stateFn func(*lexer) stateFn
 
-func (l *lexer) emit(t token) {
-	l.items <- item{t, l.input[l.start:l.pos], l.start}
+func (l *lexer) emit(t Token) {
+	l.send(t, l.input[l.start:l.pos])
 	l.start = l.pos
 }
 
+// send passes back an item starting at l.start.
+func (l *lexer) send(t Token, v string) {
+	l.position()
+	l.items = append(l.items, item{t, v, l.offset + l.start, l.line, l.col})
+}
+
+// position brings the line and column up to date with the start of the
+// current item and returns them.
+func (l *lexer) position() (line, col int) {
+	for _, r := range l.input[l.lpos:l.start] {
+		l.line, l.col = advance(l.line, l.col, r, l.tabWidth)
+	}
+	l.lpos = l.start
+	return l.line, l.col
+}
+
+// advance returns the line and column following r, which is at line and col.
+// If tabWidth is positive, tabs advance to the column after the next multiple
+// of tabWidth; otherwise every rune, including a tab, is one column.
+func advance(line, col int, r rune, tabWidth int) (int, int) {
+	switch {
+	case r == '\n':
+		return line + 1, 1
+	case r == '\t' && tabWidth > 0:
+		return line, (col-1)/tabWidth*tabWidth + tabWidth + 1
+	}
+	return line, col + 1
+}
+
 // next returns the next rune in the input.
 func (l *lexer) next() (r rune) {
 	if l.pos >= len(l.input) {
@@ -153,50 +215,201 @@ func (l *lexer) acceptRun(valid string) {
 	l.backup()
 }
 
+// warnAt passes back a warning about the input at pos, which must not
+// precede any item already sent.
+func (l *lexer) warnAt(pos int, msg string) {
+	start := l.start
+	l.start = pos
+	l.send(TokenWarning, msg)
+	l.start = start
+}
+
 // errorf returns an error token and terminates the scan by passing back a nil
-// pointer that will be the next state, terminating l.run.
+// pointer that will be the next state.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{tokenError, fmt.Sprintf(format, args...), l.start}
+	l.send(TokenError, fmt.Sprintf(format, args...))
 	return nil
 }
 
-// // nextItem returns the next item from the input.
-// func (l *lexer) nextItem() item {
-// 	for {
-// 		select {
-// 		case item := <-l.items:
-// 			return item
-// 		default:
-// 			l.state = l.state(l)
-// 		}
-// 	}
-// 	panic("unreachable")
-// }
-
-func lex(name, input string) <-chan item {
-	items := make(chan item)
+// A Scanner lexes its input on demand, in the calling goroutine, so that it
+// needs no cleanup if abandoned before the end of the input.
+type Scanner struct {
+	l     *lexer
+	state stateFn // next state, or nil once the scan has ended
+	head  int     // index of the next of l.items to return
+	end   Position
+}
 
-	go func() {
-		l := &lexer{
-			name:  name,
-			input: input,
-			items: items,
+// NewScanner returns a Scanner for input, whose name is used in reports.
+func NewScanner(name, input string) *Scanner {
+	return NewScannerConfig(name, input, Config{})
+}
+
+// NewScannerReader returns a Scanner for the input read from r. The whole
+// input is read before scanning, so positions are byte offsets into it as
+// for NewScanner, and runes split between reads are reassembled.
+func NewScannerReader(name string, r io.Reader) (*Scanner, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewScanner(name, string(b)), nil
+}
+
+// NewScannerConfig is like NewScanner, but lexes input in the mode, tab
+// width and start position of c. Its handlers are not called; errors and
+// warnings are returned as items.
+func NewScannerConfig(name, input string, c Config) *Scanner {
+	start := c.start()
+	l := &lexer{
+		name:     name,
+		input:    input,
+		mode:     c.Mode,
+		tabWidth: c.TabWidth,
+		line:     start.Line,
+		col:      start.Column,
+		offset:   start.Offset,
+		bol:      true,
+	}
+	if l.mode&SkipShebang != 0 {
+		l.skipShebang()
+	}
+	return &Scanner{l: l, state: lexTop, end: start.after(input, c.TabWidth)}
+}
+
+// Next returns the next lexeme from the input. The scan ends with an EOF or
+// an error, after which Next returns EOF lexemes positioned at the end of
+// the input.
+func (s *Scanner) Next() Lexeme {
+	if i, ok := s.next(); ok {
+		return i.lexeme(s.l.input, s.l.offset)
+	}
+	return Lexeme{TokenEOF, "", s.end.Offset, s.end.Offset, s.end.Line, s.end.Column}
+}
+
+// next returns the next item and true, or false if the scan has ended.
+func (s *Scanner) next() (item, bool) {
+	for s.head == len(s.l.items) {
+		if s.state == nil {
+			return item{}, false
+		}
+		s.l.items, s.head = s.l.items[:0], 0
+		s.state = s.state(s.l)
+	}
+	s.head++
+	return s.l.items[s.head-1], true
+}
+
+// A Lexeme is a token in the input.
+type Lexeme struct {
+	Type Token
+	// Text is the source text of the token, except that for a string it is
+	// the characters denoted and for an error or warning a message.
+	Text   string
+	Pos    int // byte offset in the input
+	End    int // byte offset just past the source text; Pos for an error or warning
+	Line   int // line number, starting at 1
+	Column int // column number in characters, starting at 1
+}
+
+// lexeme returns the Lexeme for i, lexed from input, which starts at the
+// byte offset start in its host file.
+func (i item) lexeme(input string, start int) Lexeme {
+	return Lexeme{i.t, i.v, i.pos, i.pos + len(i.raw(input, start)), i.line, i.col}
+}
+
+// Lex returns an iterator over the lexemes of input, whose name is used in
+// reports. Each call returns the next lexeme and true, or false once the
+// last lexeme, an EOF or an error, has been returned. Lexing is done on
+// demand in the calling goroutine, so the iterator may be abandoned early.
+func Lex(name, input string) func() (Lexeme, bool) {
+	s := NewScanner(name, input)
+	return func() (Lexeme, bool) {
+		if i, ok := s.next(); ok {
+			return i.lexeme(input, 0), true
 		}
-		for state := lexTop; state != nil; state = state(l) {
+		return Lexeme{}, false
+	}
+}
+
+// lex returns a channel delivering the items of input, scanned in a new
+// goroutine. The channel is closed after the last item.
+func lex(name, input string, c Config) <-chan item {
+	items := make(chan item)
+	s := NewScannerConfig(name, input, c)
+	go func() {
+		for i, ok := s.next(); ok; i, ok = s.next() {
+			items <- i
 		}
-		close(l.items) // No more tokens will be delivered.
+		close(items) // No more tokens will be delivered.
 	}()
-
 	return items
 }
 
+// Filter returns an iterator over the lexemes returned by next, an iterator
+// such as Lex returns, each transformed by f, dropping those for which f
+// returns false. Filters can be chained to build a pipeline between the
+// lexer and its consumer.
+func Filter(next func() (Lexeme, bool), f func(Lexeme) (Lexeme, bool)) func() (Lexeme, bool) {
+	return func() (Lexeme, bool) {
+		for l, ok := next(); ok; l, ok = next() {
+			if l, ok := f(l); ok {
+				return l, true
+			}
+		}
+		return Lexeme{}, false
+	}
+}
+
+// ValidateLexemes checks that lexemes is a well-formed token stream, as
+// delivered by the lexer, and returns an error describing the first
+// violation it finds. A well-formed stream ends with its only EOF or with an
+// error, its literal tokens other than strings have non-empty text, none
+// ends before its position, and their positions never decrease.
+func ValidateLexemes(lexemes []Lexeme) error {
+	if len(lexemes) == 0 {
+		return errors.New("empty token stream")
+	}
+	for n, l := range lexemes {
+		switch {
+		case l.Type == TokenEOF && n != len(lexemes)-1:
+			return fmt.Errorf("lexeme %d: EOF before the end of the stream", n)
+		case l.Type.isLiteral() && l.Type != TokenString && l.Text == "":
+			return fmt.Errorf("lexeme %d: %s with empty text", n, l.Type)
+		case l.End < l.Pos:
+			return fmt.Errorf("lexeme %d: end %d is before its position %d", n, l.End, l.Pos)
+		case n > 0 && l.Pos < lexemes[n-1].Pos:
+			return fmt.Errorf("lexeme %d: position %d is before the previous position %d", n, l.Pos, lexemes[n-1].Pos)
+		}
+	}
+	if last := lexemes[len(lexemes)-1]; last.Type != TokenEOF && last.Type != TokenError {
+		return fmt.Errorf("stream ends with %s rather than EOF or an error", last.Type)
+	}
+	return nil
+}
+
+// skipShebang skips a "#!" interpreter line at the start of the input. The
+// skipped bytes still count towards the positions of later items.
+func (l *lexer) skipShebang() {
+	if !strings.HasPrefix(l.input, "#!") {
+		return
+	}
+	if i := strings.IndexByte(l.input, '\n'); i >= 0 {
+		l.pos = i + 1
+	} else {
+		l.pos = len(l.input)
+	}
+	l.ignore()
+}
+
 const (
 	operatorChars   = "!@#$%^&*-+=~/?<>,;|‘\\"
 	smallLetter     = "abcdefghijklmnopqrstuvwxyz"
 	capitalLetter   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	digit           = "0123456789"
-	identifierStart = smallLetter + digit + "_"
-	identifierChars = identifierStart + capitalLetter
+	identifierStart = smallLetter + "_"
+	identifierChars = identifierStart + digit + capitalLetter
+	generalDigit    = digit + smallLetter + capitalLetter
 	resendSucc      = identifierStart + operatorChars
 )
 
@@ -204,21 +417,39 @@ func lexTop(l *lexer) stateFn {
 	for {
 		switch r := l.next(); {
 		case r == eof:
-			l.emit(tokenEOF)
+			// Anything pending is dropped so that EOF is positioned at the
+			// end of the input.
+			l.ignore()
+			for range l.indents {
+				l.send(TokenDedent, "")
+			}
+			l.emit(TokenEOF)
 			return nil
 		case unicode.IsSpace(r):
 			l.ignore()
+			l.bol = l.bol || r == '\n'
+		case l.bol && l.mode&IndentTokens != 0 && r != '"' && (r != '#' || l.mode&HashComments == 0):
+			l.backup()
+			l.bol = false
+			return l.indent()
+		case r == '-' && '0' <= l.peek() && l.peek() <= '9':
+			// A negative number; the parser splits it into the binary
+			// message '-' and its argument when it follows an operand.
+			l.backup()
+			return lexNumber
+		case r == '#' && l.mode&HashComments != 0:
+			return lexLineComment
 		case strings.ContainsRune(operatorChars, r):
 			return lexOperator
 		case r == '.':
-			l.emit(tokenPeriod)
+			l.emit(TokenPeriod)
 		case strings.ContainsRune(identifierStart, r):
 			return lexIdentifier
 		case 'A' <= r && r <= 'Z':
 			return lexCapKeyword
 		case r == ':':
 			return lexArgumentName
-		case r == '-' || '0' <= r && r <= '9':
+		case '0' <= r && r <= '9':
 			l.backup()
 			return lexNumber
 		case r == '\'':
@@ -226,38 +457,95 @@ func lexTop(l *lexer) stateFn {
 		case r == '"':
 			return lexComment
 		case r == '(':
-			l.emit(tokenLeftParen)
+			l.emit(TokenLeftParen)
 		case r == '{':
-			l.emit(tokenLeftBrace)
+			l.emit(TokenLeftBrace)
 		case r == '[':
-			l.emit(tokenLeftBracket)
+			l.emit(TokenLeftBracket)
 		case r == ')':
-			l.emit(tokenRightParen)
+			l.emit(TokenRightParen)
 		case r == '}':
-			l.emit(tokenRightBrace)
+			l.emit(TokenRightBrace)
 		case r == ']':
-			l.emit(tokenRightBracket)
+			l.emit(TokenRightBracket)
+		default:
+			return l.errorf("unexpected character %s", quoteRune(r))
 		}
 	}
 }
 
+// indent compares the indentation of the first token on a line with that of
+// the open levels, sending an indent for a deeper level and a dedent for
+// each level closed by a shallower one. The indentation is the token's
+// column, so comments and blank lines do not count. A line that closes
+// levels must return to the indentation of an open one.
+func (l *lexer) indent() stateFn {
+	_, col := l.position()
+	top := func() int {
+		if len(l.indents) == 0 {
+			return 1
+		}
+		return l.indents[len(l.indents)-1]
+	}
+	if col > top() {
+		l.indents = append(l.indents, col)
+		l.send(TokenIndent, "")
+		return lexTop
+	}
+	for col < top() {
+		l.indents = l.indents[:len(l.indents)-1]
+		l.send(TokenDedent, "")
+	}
+	if col != top() {
+		return l.errorf("unindent does not match any outer indentation level")
+	}
+	return lexTop
+}
+
+// quoteRune returns r quoted for an error message, or, if r is not
+// printable, its code point, such as "U+0001", so that control characters
+// can be identified.
+func quoteRune(r rune) string {
+	if unicode.IsPrint(r) {
+		return strconv.QuoteRune(r)
+	}
+	return fmt.Sprintf("U+%04X", r)
+}
+
+// lexOperator scans a run of operator characters. Self has no cascades and
+// separates statements with periods, so ',' and ';' are ordinary operator
+// characters: "a , b" sends the binary message ',', conventionally
+// concatenation, to a.
 func lexOperator(l *lexer) stateFn {
 	l.acceptRun(operatorChars)
 	switch l.input[l.start:l.pos] {
 	case "<-":
-		l.emit(tokenLeftArrow)
+		l.emit(TokenLeftArrow)
 	case "=":
-		l.emit(tokenEqual)
+		l.emit(TokenEqual)
 	case "|":
-		l.emit(tokenBar)
+		l.emit(TokenBar)
 	case "^":
-		l.emit(tokenCaret)
+		l.emit(TokenCaret)
+	case "*":
+		l.emit(TokenStar)
 	case "\\":
-		if c := l.peek(); c != '\n' && c != '\r' {
-			l.emit(tokenOperator)
+		switch l.peek() {
+		case '\n', '\r':
+			// A line continuation: the backslash and the line break
+			// after it are skipped, so the next line does not start a
+			// new one for IndentTokens.
+			l.accept("\r")
+			l.accept("\n")
+			l.ignore()
+		default:
+			// A backslash followed by anything else, including the end of
+			// the input, continues nothing, so it is an operator; at the end,
+			// the parser reports its missing operand.
+			l.emit(TokenOperator)
 		}
 	default:
-		l.emit(tokenOperator)
+		l.emit(TokenOperator)
 	}
 	return lexTop
 }
@@ -265,11 +553,11 @@ func lexOperator(l *lexer) stateFn {
 func (l *lexer) resend() stateFn {
 	switch l.input[l.start:l.pos] {
 	case "resend.":
-		l.emit(tokenResend)
+		l.emit(TokenResend)
 	case "self.":
 		return l.errorf("using 'self' as a parent name for a directed resend")
 	default:
-		l.emit(tokenDelegate)
+		l.emit(TokenDelegate)
 	}
 	return lexTop
 }
@@ -277,11 +565,17 @@ func (l *lexer) resend() stateFn {
 func (l *lexer) identifier() stateFn {
 	switch l.input[l.start:l.pos] {
 	case "self":
-		l.emit(tokenSelf)
+		l.emit(TokenSelf)
 	case "resend":
 		return l.errorf("using 'resend' outside of a resend")
+	case "_":
+		if l.mode&Placeholders != 0 {
+			l.emit(TokenPlaceholder)
+		} else {
+			l.emit(TokenIdentifier)
+		}
 	default:
-		l.emit(tokenIdentifier)
+		l.emit(TokenIdentifier)
 	}
 	return lexTop
 }
@@ -293,15 +587,18 @@ func (l *lexer) argumentName() stateFn {
 	case ":resend":
 		return l.errorf("using 'resend' as an argument")
 	}
-	l.emit(tokenArgumentName)
+	l.emit(TokenArgumentName)
 	return lexTop
 }
 
+// lexIdentifier scans an identifier, which starts with a lowercase letter or
+// '_' and may continue with letters of either case, digits and '_', so that
+// "fooBar" is a single identifier. A following ':' makes it a small keyword.
 func lexIdentifier(l *lexer) stateFn {
 	l.acceptRun(identifierChars)
 	switch {
 	case l.accept(":"):
-		l.emit(tokenSmallKeyword)
+		l.emit(TokenSmallKeyword)
 		return lexTop
 	case l.accept("."):
 		w := l.width
@@ -313,13 +610,15 @@ func lexIdentifier(l *lexer) stateFn {
 	return l.identifier()
 }
 
+// lexCapKeyword scans a capitalized keyword. Only keywords may start with a
+// capital letter, so "FooBar" without a trailing ':' is an error.
 func lexCapKeyword(l *lexer) stateFn {
 	l.acceptRun(identifierChars)
 	if l.accept(":") {
-		l.emit(tokenCapKeyword)
+		l.emit(TokenCapKeyword)
 		return lexTop
 	}
-	return l.errorf("expected ':', found %q", l.peek())
+	return l.errorf("expected ':', found %s", quoteRune(l.peek()))
 }
 
 func lexArgumentName(l *lexer) stateFn {
@@ -327,29 +626,241 @@ func lexArgumentName(l *lexer) stateFn {
 		l.acceptRun(identifierChars)
 		return l.argumentName()
 	}
-	return l.errorf("expected lowercase letter or '_', found %q", l.peek())
+	return l.errorf("expected lowercase letter or '_', found %s", quoteRune(l.peek()))
 }
 
+// lexComment scans a comment, which runs to the next '"'. Comments have no
+// escapes, so a backslash in a comment is an ordinary character and a
+// comment cannot contain '"'; "" is an empty comment. The value of a comment
+// item is therefore always its exact source text.
 func lexComment(l *lexer) stateFn {
 	r := l.next()
 	for r != '"' && r != eof {
 		r = l.next()
 	}
 	if r == '"' {
-		l.ignore()
+		if l.mode&ParseComments != 0 {
+			l.emit(TokenComment)
+		} else {
+			l.ignore()
+		}
 		return lexTop
 	}
 	return l.errorf("unclosed comment")
 }
 
+// lexLineComment scans a comment running from '#' to the end of the line,
+// in HashComments mode.
+func lexLineComment(l *lexer) stateFn {
+	if i := strings.IndexByte(l.input[l.pos:], '\n'); i >= 0 {
+		l.pos += i
+	} else {
+		l.pos = len(l.input)
+	}
+	if l.mode&ParseComments != 0 {
+		l.emit(TokenComment)
+	} else {
+		l.ignore()
+	}
+	return lexTop
+}
+
 func lexString(l *lexer) stateFn {
-	// TODO
-	return nil
+	for {
+		switch l.next() {
+		case '\\':
+			c := l.next()
+			if c == eof {
+				return l.errorf("unclosed string literal")
+			}
+			if e, ok := numericEscapes[c]; ok {
+				if !l.numericEscape(c, e.base, e.n) {
+					return nil
+				}
+				continue
+			}
+			if _, ok := escapes[c]; !ok {
+				return l.errorf("unknown escape sequence: '\\' followed by %s", quoteRune(c))
+			}
+		case '\'':
+			start := l.start
+			l.send(TokenString, unquote(l.input[start+1:l.pos-1]))
+			l.ignore()
+			if l.mode&CheckStringTabs != 0 {
+				for i := start; i < l.pos; i++ {
+					if l.input[i] == '\t' {
+						l.warnAt(i, "raw tab in string literal; use '\\t'")
+					}
+				}
+			}
+			return lexTop
+		case eof:
+			if l.mode&RecoverStrings != 0 {
+				return l.recoverString()
+			}
+			return l.errorf("unclosed string literal")
+		}
+	}
+}
+
+// recoverString reports an unclosed string literal, then passes back the
+// rest of its first line as a string, without a closing quote, so that
+// lexing can continue on the next line.
+func (l *lexer) recoverString() stateFn {
+	l.send(TokenError, "unclosed string literal")
+	if i := strings.IndexByte(l.input[l.start:], '\n'); i >= 0 {
+		l.pos = l.start + i
+	}
+	l.send(TokenString, unquote(l.input[l.start+1:l.pos]))
+	l.ignore()
+	return lexTop
+}
+
+// escapes maps the character following a '\' in a string literal to the
+// character it denotes.
+var escapes = map[rune]rune{
+	't': '\t', 'b': '\b', 'n': '\n', 'f': '\f', 'r': '\r', 'v': '\v', 'a': '\a', '0': 0,
+	'\\': '\\', '\'': '\'', '"': '"', '?': '?',
 }
 
+// numericEscapes maps the letters introducing numeric escapes to the base
+// and number of their digits. Each numeric escape denotes a single byte.
+var numericEscapes = map[rune]struct{ base, n int }{'x': {16, 2}, 'd': {10, 3}, 'o': {8, 3}}
+
+// numericEscape scans the n digits in base of a numeric escape introduced by
+// c. It reports whether they are valid, emitting an error if not.
+func (l *lexer) numericEscape(c rune, base, n int) bool {
+	start := l.pos
+	for i := 0; i < n; i++ {
+		if r := l.next(); !strings.ContainsRune(generalDigit, r) || digitVal(r) >= base {
+			l.errorf("escape sequence '\\%c' needs %d base %d digits", c, n, base)
+			return false
+		}
+	}
+	if v, _ := strconv.ParseUint(l.input[start:l.pos], base, 16); v > 255 {
+		l.errorf("escape sequence '\\%c%s' is out of range (0..255)", c, l.input[start:l.pos])
+		return false
+	}
+	return true
+}
+
+// unquote returns the characters denoted by the contents of a string literal
+// lexed by lexString, between its quotes, with escapes replaced.
+func unquote(s string) string {
+	if !strings.ContainsRune(s, '\\') {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			break // a recovered string may end in a backslash
+		}
+		c := rune(s[i])
+		if e, ok := numericEscapes[c]; ok {
+			if i+1+e.n > len(s) {
+				break
+			}
+			v, _ := strconv.ParseUint(s[i+1:i+1+e.n], e.base, 8)
+			b.WriteByte(byte(v))
+			i += e.n
+			continue
+		}
+		b.WriteRune(escapes[c])
+	}
+	return b.String()
+}
+
+// raw returns the source text of i, lexed from input, which starts at the
+// byte offset start in its host file, as given by Config.Start. It differs
+// from the value of i only for strings, whose values have their quotes
+// removed and escapes replaced, and for errors and warnings, whose values
+// are messages and whose source text is empty.
+func (i item) raw(input string, start int) string {
+	switch i.t {
+	case TokenError, TokenWarning:
+		return ""
+	case TokenString:
+		pos := i.pos - start
+		for j := pos + 1; j < len(input); j++ {
+			switch input[j] {
+			case '\\':
+				j++
+			case '\'':
+				return input[pos : j+1]
+			}
+		}
+		// An unclosed string recovered in RecoverStrings mode ends at the
+		// end of its line.
+		if j := strings.IndexByte(input[pos:], '\n'); j >= 0 {
+			return input[pos : pos+j]
+		}
+		return input[pos:]
+	}
+	return i.v
+}
+
+// lexNumber scans a number, optionally negative: an integer, a real such as
+// "3.14", "1e10" or "2.5e-3", or an integer in a radix from 2 to 36, such as
+// "16rFF", whose digits must all be valid in the radix. Radix numbers have
+// neither an exponent, since 'e' is a digit in radixes above 14, nor a
+// fraction, since fixed-point numbers are decimal: "16r1.8" is the integer
+// 16r1 followed by a period. A period not followed by a digit ends the
+// statement rather than the number, so "3." is the number 3 followed by a
+// period. Numbers start with a digit, or a '-' followed by one, so
+// "foo16rbar" is an identifier rather than a number.
 func lexNumber(l *lexer) stateFn {
-	// TODO
-	return nil
+	l.accept("-")
+	digits := l.pos
+	l.acceptRun(digit)
+	if l.accept("rR") {
+		base, err := strconv.Atoi(l.input[digits : l.pos-1])
+		if err != nil || base < 2 || base > 36 {
+			return l.errorf("radix out of range (2..36)")
+		}
+		start := l.pos
+		for r := l.next(); strings.ContainsRune(generalDigit, r); r = l.next() {
+			if digitVal(r) >= base {
+				return l.errorf("invalid digit %s in base %d number", quoteRune(r), base)
+			}
+		}
+		l.backup()
+		if l.pos == start {
+			return l.errorf("missing digits in base %d number", base)
+		}
+		l.emit(TokenNumber)
+		return lexTop
+	}
+	if l.accept(".") {
+		if l.accept(digit) {
+			l.acceptRun(digit)
+		} else {
+			l.pos-- // the period separates statements
+		}
+	}
+	if l.accept("eE") {
+		l.accept("+-")
+		if !l.accept(digit) {
+			return l.errorf("missing digits in exponent")
+		}
+		l.acceptRun(digit)
+	}
+	l.emit(TokenNumber)
+	return lexTop
+}
+
+// digitVal returns the value of r, a member of generalDigit.
+func digitVal(r rune) int {
+	switch {
+	case '0' <= r && r <= '9':
+		return int(r - '0')
+	case 'a' <= r && r <= 'z':
+		return int(r-'a') + 10
+	}
+	return int(r-'A') + 10
 }
 
 // number  → [ ‘-’ ] (integer | real)
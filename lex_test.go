@@ -1,18 +1,37 @@
 package ego
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 type test struct {
 	source string
-	tokens []token
+	tokens []Token
 }
 
 func TestLex(t *testing.T) {
 	tests := []test{
-		{"", []token{}},
-		{"  <- :arg", []token{tokenLeftArrow, tokenArgumentName}},
+		{"", []Token{}},
+		{"  <- :arg", []Token{TokenLeftArrow, TokenArgumentName}},
+		{"3. foo", []Token{TokenNumber, TokenPeriod, TokenIdentifier}},
+		{"3.14", []Token{TokenNumber}},
+		{"3.14. bar", []Token{TokenNumber, TokenPeriod, TokenIdentifier}},
+		{"3.", []Token{TokenNumber, TokenPeriod}},
+		{"a \\", []Token{TokenIdentifier, TokenOperator}},
+		{"parent* = x", []Token{TokenIdentifier, TokenStar, TokenEqual, TokenIdentifier}},
+		{"a ** b", []Token{TokenIdentifier, TokenOperator, TokenIdentifier}},
+		{"a , b", []Token{TokenIdentifier, TokenOperator, TokenIdentifier}},
+		{"a,b", []Token{TokenIdentifier, TokenOperator, TokenIdentifier}},
+		{"a ,, b", []Token{TokenIdentifier, TokenOperator, TokenIdentifier}},
+		{"a % b", []Token{TokenIdentifier, TokenOperator, TokenIdentifier}},
+		{"7%2", []Token{TokenNumber, TokenOperator, TokenNumber}},
 	}
 	for i, test := range tests {
 		test.test(t, i)
@@ -20,13 +39,859 @@ func TestLex(t *testing.T) {
 }
 
 func (test *test) test(t *testing.T, n int) {
-	items := lex("test", test.source)
+	items := lex("test", test.source, Config{})
 	for i, expected := range test.tokens {
 		if item := <-items; item.t != expected {
 			t.Errorf("[%d] expected %s but found %s (%s) at %d", n, tokens[expected], tokens[item.t], item, i)
 		}
 	}
-	if item := <-items; item.t != tokenEOF {
+	if item := <-items; item.t != TokenEOF {
 		t.Errorf("[%d] expected EOF but found %s (%s)", n, tokens[item.t], item)
 	}
 }
+
+func TestLexEOFPosition(t *testing.T) {
+	for _, source := range []string{"", "foo", "foo  ", "foo \"comment\"", "a \\\n", "a\n\t\n", "x <- 'é'"} {
+		var last item
+		for i := range lex("test", source, Config{}) {
+			last = i
+		}
+		if last.t != TokenEOF || last.pos != len(source) {
+			t.Errorf("%q: expected EOF at %d but found %s %s at %d", source, len(source), tokens[last.t], last, last.pos)
+		}
+	}
+}
+
+func TestLexShebang(t *testing.T) {
+	source := "#!/usr/bin/env ego\nfoo bar"
+	items := lex("test", source, Config{Mode: SkipShebang})
+	for _, expected := range []item{{TokenIdentifier, "foo", 19, 2, 1}, {TokenIdentifier, "bar", 23, 2, 5}, {TokenEOF, "", 26, 2, 8}} {
+		if i := <-items; i != expected {
+			t.Errorf("expected %s at %d but found %s at %d", expected, expected.pos, i, i.pos)
+		}
+	}
+
+	items = lex("test", source, Config{})
+	if i := <-items; i.t != TokenOperator || i.v != "#!/" || i.pos != 0 {
+		t.Errorf("expected operator \"#!/\" at 0 but found %s %s at %d", tokens[i.t], i, i.pos)
+	}
+	for range items {
+	}
+
+	items = lex("test", "foo bar", Config{Mode: SkipShebang})
+	if i := <-items; i.t != TokenIdentifier || i.pos != 0 {
+		t.Errorf("expected identifier at 0 but found %s %s at %d", tokens[i.t], i, i.pos)
+	}
+	for range items {
+	}
+}
+
+func TestLexRadix(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected item
+	}{
+		{"36rZ", item{TokenNumber, "36rZ", 0, 1, 1}},
+		{"2r1010", item{TokenNumber, "2r1010", 0, 1, 1}},
+		{"16Rff", item{TokenNumber, "16Rff", 0, 1, 1}},
+		{"1rX", item{TokenError, "radix out of range (2..36)", 0, 1, 1}},
+		{"40rZ", item{TokenError, "radix out of range (2..36)", 0, 1, 1}},
+		{"0r0", item{TokenError, "radix out of range (2..36)", 0, 1, 1}},
+		{"16rFFE", item{TokenNumber, "16rFFE", 0, 1, 1}},
+		{"16rFFe2", item{TokenNumber, "16rFFe2", 0, 1, 1}},
+		{"10r1e2", item{TokenError, "invalid digit 'e' in base 10 number", 0, 1, 1}},
+		{"2r102", item{TokenError, "invalid digit '2' in base 2 number", 0, 1, 1}},
+		{"2r1012", item{TokenError, "invalid digit '2' in base 2 number", 0, 1, 1}},
+		{"37r1", item{TokenError, "radix out of range (2..36)", 0, 1, 1}},
+		{"16rff. x", item{TokenNumber, "16rff", 0, 1, 1}},
+		{"8r777 x", item{TokenNumber, "8r777", 0, 1, 1}},
+		{"16rFG", item{TokenError, "invalid digit 'G' in base 16 number", 0, 1, 1}},
+		{"36rZz9", item{TokenNumber, "36rZz9", 0, 1, 1}},
+		{"16rFF", item{TokenNumber, "16rFF", 0, 1, 1}},
+		{"16rG", item{TokenError, "invalid digit 'G' in base 16 number", 0, 1, 1}},
+		{"16r", item{TokenError, "missing digits in base 16 number", 0, 1, 1}},
+		{"2r. x", item{TokenError, "missing digits in base 2 number", 0, 1, 1}},
+		{"foo16rbar", item{TokenIdentifier, "foo16rbar", 0, 1, 1}},
+		{"x16rFF", item{TokenIdentifier, "x16rFF", 0, 1, 1}},
+		{"r16", item{TokenIdentifier, "r16", 0, 1, 1}},
+	}
+	for _, test := range tests {
+		items := lex("test", test.source, Config{})
+		if i := <-items; i != test.expected {
+			t.Errorf("%q: expected %s %s but found %s %s", test.source, tokens[test.expected.t], test.expected, tokens[i.t], i)
+		}
+		for range items {
+		}
+	}
+}
+
+func TestLexNumber(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected item
+	}{
+		{"0", item{TokenNumber, "0", 0, 1, 1}},
+		{"-17", item{TokenNumber, "-17", 0, 1, 1}},
+		{"3.14", item{TokenNumber, "3.14", 0, 1, 1}},
+		{"6.022e23", item{TokenNumber, "6.022e23", 0, 1, 1}},
+		{"1.0e-9", item{TokenNumber, "1.0e-9", 0, 1, 1}},
+		{"-2.5E+3", item{TokenNumber, "-2.5E+3", 0, 1, 1}},
+		{"1e", item{TokenError, "missing digits in exponent", 0, 1, 1}},
+		{"1e-", item{TokenError, "missing digits in exponent", 0, 1, 1}},
+		{"3ex", item{TokenError, "missing digits in exponent", 0, 1, 1}},
+		{"1.5e+x", item{TokenError, "missing digits in exponent", 0, 1, 1}},
+	}
+	for _, test := range tests {
+		items := lex("test", test.source, Config{})
+		if i := <-items; i != test.expected {
+			t.Errorf("%q: expected %s %s but found %s %s", test.source, tokens[test.expected.t], test.expected, tokens[i.t], i)
+		}
+		for range items {
+		}
+	}
+}
+
+func TestLexExponent(t *testing.T) {
+	tests := []struct {
+		source string
+		tokens []item
+	}{
+		{"1e2", []item{{TokenNumber, "1e2", 0, 1, 1}}},
+		{"1E+2", []item{{TokenNumber, "1E+2", 0, 1, 1}}},
+		{"2.5e-3", []item{{TokenNumber, "2.5e-3", 0, 1, 1}}},
+		{"3 ex", []item{{TokenNumber, "3", 0, 1, 1}, {TokenIdentifier, "ex", 2, 1, 3}}},
+		{"a-1", []item{{TokenIdentifier, "a", 0, 1, 1}, {TokenNumber, "-1", 1, 1, 2}}},
+		{"a - 1", []item{{TokenIdentifier, "a", 0, 1, 1}, {TokenOperator, "-", 2, 1, 3}, {TokenNumber, "1", 4, 1, 5}}},
+		{"a -> 1", []item{{TokenIdentifier, "a", 0, 1, 1}, {TokenOperator, "->", 2, 1, 3}, {TokenNumber, "1", 5, 1, 6}}},
+		{"1e2. x", []item{{TokenNumber, "1e2", 0, 1, 1}, {TokenPeriod, ".", 3, 1, 4}, {TokenIdentifier, "x", 5, 1, 6}}},
+	}
+	for _, test := range tests {
+		items := lex("test", test.source, Config{})
+		for _, expected := range test.tokens {
+			if i := <-items; i != expected {
+				t.Errorf("%q: expected %s %s but found %s %s", test.source, tokens[expected.t], expected, tokens[i.t], i)
+			}
+		}
+		if i := <-items; i.t != TokenEOF {
+			t.Errorf("%q: expected EOF but found %s %s", test.source, tokens[i.t], i)
+		}
+	}
+}
+
+func TestLexString(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected item
+	}{
+		{"''", item{TokenString, "", 0, 1, 1}},
+		{"'abc'", item{TokenString, "abc", 0, 1, 1}},
+		{`'\t\b\n\f\r\v\a\0'`, item{TokenString, "\t\b\n\f\r\v\a\x00", 0, 1, 1}},
+		{`'\\ \' \" \?'`, item{TokenString, `\ ' " ?`, 0, 1, 1}},
+		{`'say \"hi\"'`, item{TokenString, `say "hi"`, 0, 1, 1}},
+		{"'two\nlines'", item{TokenString, "two\nlines", 0, 1, 1}},
+		{"'", item{TokenError, "unclosed string literal", 0, 1, 1}},
+		{"'abc", item{TokenError, "unclosed string literal", 0, 1, 1}},
+		{`'abc\'`, item{TokenError, "unclosed string literal", 0, 1, 1}},
+		{`'abc\`, item{TokenError, "unclosed string literal", 0, 1, 1}},
+		{`'\q'`, item{TokenError, "unknown escape sequence: '\\' followed by 'q'", 0, 1, 1}},
+		{`'\x41'`, item{TokenString, "A", 0, 1, 1}},
+		{`'\o101'`, item{TokenString, "A", 0, 1, 1}},
+		{`'\d065'`, item{TokenString, "A", 0, 1, 1}},
+		{`'\xfF\d255\o377\x00'`, item{TokenString, "\xff\xff\xff\x00", 0, 1, 1}},
+		{`'\x414'`, item{TokenString, "A4", 0, 1, 1}},
+		{`'\xZZ'`, item{TokenError, "escape sequence '\\x' needs 2 base 16 digits", 0, 1, 1}},
+		{`'\x4'`, item{TokenError, "escape sequence '\\x' needs 2 base 16 digits", 0, 1, 1}},
+		{`'\d06'`, item{TokenError, "escape sequence '\\d' needs 3 base 10 digits", 0, 1, 1}},
+		{`'\o108'`, item{TokenError, "escape sequence '\\o' needs 3 base 8 digits", 0, 1, 1}},
+		{`'\d256'`, item{TokenError, "escape sequence '\\d256' is out of range (0..255)", 0, 1, 1}},
+		{`'\o400'`, item{TokenError, "escape sequence '\\o400' is out of range (0..255)", 0, 1, 1}},
+	}
+	for _, test := range tests {
+		items := lex("test", test.source, Config{})
+		i := <-items
+		if i != test.expected {
+			t.Errorf("%q: expected %s %q but found %s %q", test.source, tokens[test.expected.t], test.expected.v, tokens[i.t], i.v)
+		} else if i.t == TokenString {
+			if raw := i.raw(test.source, 0); raw != test.source {
+				t.Errorf("%q: expected raw text %q but found %q", test.source, test.source, raw)
+			}
+			if i := <-items; i.t != TokenEOF {
+				t.Errorf("%q: expected EOF but found %s %s", test.source, tokens[i.t], i)
+			}
+		}
+		for range items {
+		}
+	}
+}
+
+func TestLexBadNumericEscape(t *testing.T) {
+	tests := []struct {
+		source string
+		mode   Mode
+		error  string
+	}{
+		{`'\x''`, 0, "escape sequence '\\x' needs 2 base 16 digits"},
+		{`'\x`, 0, "escape sequence '\\x' needs 2 base 16 digits"},
+		{`'\x`, RecoverStrings, "escape sequence '\\x' needs 2 base 16 digits"},
+		{`'\xZZ' foo`, 0, "escape sequence '\\x' needs 2 base 16 digits"},
+		{`'\d999' foo`, RecoverStrings, "escape sequence '\\d999' is out of range (0..255)"},
+	}
+	for _, test := range tests {
+		expected := []item{{TokenError, test.error, 0, 1, 1}}
+		if items := collect(lex("test", test.source, Config{Mode: test.mode})); !reflect.DeepEqual(items, expected) {
+			t.Errorf("%s: expected %v but found %v", test.source, expected, items)
+		}
+	}
+	if s := unquote(`a\x4`); s != "a" {
+		t.Errorf("expected a truncated escape to be dropped but found %q", s)
+	}
+}
+
+func TestLexEscapes(t *testing.T) {
+	for c, r := range escapes {
+		source := "'a\\" + string(c) + "b'"
+		i := <-lex("test", source, Config{})
+		if expected := "a" + string(r) + "b"; i.t != TokenString || i.v != expected {
+			t.Errorf("%q: expected string %q but found %s %q", source, expected, tokens[i.t], i.v)
+		}
+	}
+}
+
+func TestLexBackspaceFormFeed(t *testing.T) {
+	tests := []struct {
+		source string
+		bytes  []byte
+	}{
+		{`'\b'`, []byte{0x08}},
+		{`'\f'`, []byte{0x0c}},
+		{`'a\bb\fc'`, []byte{'a', 0x08, 'b', 0x0c, 'c'}},
+	}
+	for _, test := range tests {
+		items := collect(lex("test", test.source, Config{}))
+		if i := items[0]; i.t != TokenString || i.v != string(test.bytes) {
+			t.Errorf("%s: expected string % x but found %s % x", test.source, test.bytes, i.t, i.v)
+		}
+	}
+}
+
+func TestItemRaw(t *testing.T) {
+	source := "foo: 'a\\'b' Bar: 16rFF. 'x\ny' 'unclosed\nz"
+	var raws []string
+	for i := range lex("test", source, Config{Mode: RecoverStrings}) {
+		raws = append(raws, i.raw(source, 0))
+	}
+	expected := []string{"foo:", `'a\'b'`, "Bar:", "16rFF", ".", "'x\ny'", "", "'unclosed", "z", ""}
+	if !reflect.DeepEqual(raws, expected) {
+		t.Errorf("expected %q but found %q", expected, raws)
+	}
+
+	raws = nil
+	for i := range lex("test", source, Config{Mode: RecoverStrings, Start: Position{Offset: 100, Line: 5, Column: 1}}) {
+		raws = append(raws, i.raw(source, 100))
+	}
+	if !reflect.DeepEqual(raws, expected) {
+		t.Errorf("with a start: expected %q but found %q", expected, raws)
+	}
+}
+
+func TestLexComments(t *testing.T) {
+	tests := []struct {
+		mode   Mode
+		tokens []Token
+	}{
+		{0, []Token{TokenIdentifier}},
+		{ParseComments, []Token{TokenComment, TokenIdentifier, TokenComment}},
+	}
+	for _, test := range tests {
+		items := lex("test", `"doc" a "trailing"`, Config{Mode: test.mode})
+		for _, expected := range test.tokens {
+			if i := <-items; i.t != expected {
+				t.Errorf("mode %d: expected %s but found %s (%s)", test.mode, tokens[expected], tokens[i.t], i)
+			}
+		}
+		if i := <-items; i.t != TokenEOF {
+			t.Errorf("mode %d: expected EOF but found %s (%s)", test.mode, tokens[i.t], i)
+		}
+	}
+}
+
+func TestLexHashComments(t *testing.T) {
+	tests := []struct {
+		source string
+		mode   Mode
+		items  []item
+	}{
+		{"a # b", 0, []item{
+			{TokenIdentifier, "a", 0, 1, 1},
+			{TokenOperator, "#", 2, 1, 3},
+			{TokenIdentifier, "b", 4, 1, 5},
+		}},
+		{"# comment\na # b", HashComments, []item{
+			{TokenIdentifier, "a", 10, 2, 1},
+		}},
+		{"# comment\na # b", HashComments | ParseComments, []item{
+			{TokenComment, "# comment", 0, 1, 1},
+			{TokenIdentifier, "a", 10, 2, 1},
+			{TokenComment, "# b", 12, 2, 3},
+		}},
+		{`a "x" #`, HashComments | ParseComments, []item{
+			{TokenIdentifier, "a", 0, 1, 1},
+			{TokenComment, `"x"`, 2, 1, 3},
+			{TokenComment, "#", 6, 1, 7},
+		}},
+	}
+	for _, test := range tests {
+		var found []item
+		for i := range lex("test", test.source, Config{Mode: test.mode}) {
+			if i.t != TokenEOF {
+				found = append(found, i)
+			}
+		}
+		if !reflect.DeepEqual(found, test.items) {
+			t.Errorf("%q: expected %v but found %v", test.source, test.items, found)
+		}
+	}
+}
+
+func TestLexIndentTokens(t *testing.T) {
+	tests := []struct {
+		source string
+		tokens []Token
+	}{
+		{"a\n  b\n    c\n  d\ne", []Token{
+			TokenIdentifier,
+			TokenIndent, TokenIdentifier,
+			TokenIndent, TokenIdentifier,
+			TokenDedent, TokenIdentifier,
+			TokenDedent, TokenIdentifier,
+			TokenEOF,
+		}},
+		{"a\n  b\n    c", []Token{
+			TokenIdentifier, TokenIndent, TokenIdentifier, TokenIndent, TokenIdentifier, TokenDedent, TokenDedent, TokenEOF,
+		}},
+		{"a foo\n\n  \"note\"\n\t\n  b. c\n  d", []Token{
+			TokenIdentifier, TokenIdentifier, TokenIndent, TokenIdentifier, TokenPeriod, TokenIdentifier, TokenIdentifier, TokenDedent, TokenEOF,
+		}},
+		{"a\n    b\n  c", []Token{TokenIdentifier, TokenIndent, TokenIdentifier, TokenDedent, TokenError}},
+	}
+	for _, test := range tests {
+		var found []Token
+		for i := range lex("test", test.source, Config{Mode: IndentTokens}) {
+			found = append(found, i.t)
+		}
+		if !reflect.DeepEqual(found, test.tokens) {
+			t.Errorf("%q: expected %v but found %v", test.source, test.tokens, found)
+		}
+	}
+
+	items := collect(lex("test", "a\n  b\nc", Config{Mode: IndentTokens}))
+	expected := []item{
+		{TokenIdentifier, "a", 0, 1, 1},
+		{TokenIndent, "", 4, 2, 3},
+		{TokenIdentifier, "b", 4, 2, 3},
+		{TokenDedent, "", 6, 3, 1},
+		{TokenIdentifier, "c", 6, 3, 1},
+		{TokenEOF, "", 7, 3, 2},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("expected %v but found %v", expected, items)
+	}
+	if items := collect(lex("test", "a\n  b", Config{})); len(items) != 3 {
+		t.Errorf("expected no indentation tokens by default but found %v", items)
+	}
+}
+
+func TestLexLineContinuation(t *testing.T) {
+	tests := []struct {
+		source string
+		mode   Mode
+		items  []item
+	}{
+		{"foo \\\nbar", 0, []item{
+			{TokenIdentifier, "foo", 0, 1, 1},
+			{TokenIdentifier, "bar", 6, 2, 1},
+			{TokenEOF, "", 9, 2, 4},
+		}},
+		{"foo \\\r\nbar", 0, []item{
+			{TokenIdentifier, "foo", 0, 1, 1},
+			{TokenIdentifier, "bar", 7, 2, 1},
+			{TokenEOF, "", 10, 2, 4},
+		}},
+		{"a + \\\n  b", 0, []item{
+			{TokenIdentifier, "a", 0, 1, 1},
+			{TokenOperator, "+", 2, 1, 3},
+			{TokenIdentifier, "b", 8, 2, 3},
+			{TokenEOF, "", 9, 2, 4},
+		}},
+		{"a \\\n  b\nc", IndentTokens, []item{
+			{TokenIdentifier, "a", 0, 1, 1},
+			{TokenIdentifier, "b", 6, 2, 3},
+			{TokenIdentifier, "c", 8, 3, 1},
+			{TokenEOF, "", 9, 3, 2},
+		}},
+	}
+	for _, test := range tests {
+		if items := collect(lex("test", test.source, Config{Mode: test.mode})); !reflect.DeepEqual(items, test.items) {
+			t.Errorf("%q: expected %v but found %v", test.source, test.items, items)
+		}
+	}
+}
+
+func TestLexUnexpectedCharacter(t *testing.T) {
+	tests := []struct {
+		source string
+		err    item
+	}{
+		{"a \x01 b", item{TokenError, "unexpected character U+0001", 2, 1, 3}},
+		{"a `b", item{TokenError, "unexpected character '`'", 2, 1, 3}},
+		{"a\n  é", item{TokenError, "unexpected character 'é'", 4, 2, 3}},
+		{"a \u200b", item{TokenError, "unexpected character U+200B", 2, 1, 3}},
+		{"Foo\x7f", item{TokenError, "expected ':', found U+007F", 0, 1, 1}},
+		{":\x1b", item{TokenError, "expected lowercase letter or '_', found U+001B", 0, 1, 1}},
+		{"'\\\x07'", item{TokenError, "unknown escape sequence: '\\' followed by U+0007", 0, 1, 1}},
+	}
+	for _, test := range tests {
+		var last item
+		for i := range lex("test", test.source, Config{}) {
+			last = i
+		}
+		if last != test.err {
+			t.Errorf("%q: expected %s at %d but found %s %s at %d", test.source, test.err, test.err.pos, tokens[last.t], last, last.pos)
+		}
+	}
+}
+
+func TestLexCommentText(t *testing.T) {
+	source := `"a \n b" "c\" d "" e`
+	var comments []string
+	for i := range lex("test", source, Config{Mode: ParseComments}) {
+		if i.t == TokenComment {
+			if raw := i.raw(source, 0); raw != i.v {
+				t.Errorf("expected raw text %q to equal value %q", raw, i.v)
+			}
+			comments = append(comments, i.v)
+		}
+	}
+	expected := []string{`"a \n b"`, `"c\"`, `""`}
+	if !reflect.DeepEqual(comments, expected) {
+		t.Errorf("expected %q but found %q", expected, comments)
+	}
+}
+
+func TestLexCommentQuotes(t *testing.T) {
+	tests := []struct {
+		source string
+		items  []item
+	}{
+		// Comments neither nest nor escape quotes, as in Self.
+		{`"outer "inner" text"`, []item{
+			{TokenComment, `"outer "`, 0, 1, 1},
+			{TokenIdentifier, "inner", 8, 1, 9},
+			{TokenComment, `" text"`, 13, 1, 14},
+			{TokenEOF, "", 20, 1, 21},
+		}},
+		{`""""`, []item{
+			{TokenComment, `""`, 0, 1, 1},
+			{TokenComment, `""`, 2, 1, 3},
+			{TokenEOF, "", 4, 1, 5},
+		}},
+		{`"a "b" c`, []item{
+			{TokenComment, `"a "`, 0, 1, 1},
+			{TokenIdentifier, "b", 4, 1, 5},
+			{TokenError, "unclosed comment", 5, 1, 6},
+		}},
+	}
+	for _, test := range tests {
+		if items := collect(lex("test", test.source, Config{Mode: ParseComments})); !reflect.DeepEqual(items, test.items) {
+			t.Errorf("%q: expected %v but found %v", test.source, test.items, items)
+		}
+	}
+}
+
+func TestLexPlaceholder(t *testing.T) {
+	tests := []struct {
+		mode   Mode
+		tokens []Token
+	}{
+		{0, []Token{TokenIdentifier, TokenIdentifier, TokenArgumentName}},
+		{Placeholders, []Token{TokenPlaceholder, TokenIdentifier, TokenArgumentName}},
+	}
+	for _, test := range tests {
+		items := lex("test", "_ _foo :_", Config{Mode: test.mode})
+		for _, expected := range test.tokens {
+			if i := <-items; i.t != expected {
+				t.Errorf("mode %d: expected %s but found %s (%s)", test.mode, tokens[expected], tokens[i.t], i)
+			}
+		}
+		if i := <-items; i.t != TokenEOF {
+			t.Errorf("mode %d: expected EOF but found %s (%s)", test.mode, tokens[i.t], i)
+		}
+	}
+}
+
+func TestLexNumberPeriod(t *testing.T) {
+	tests := []struct {
+		source string
+		number string
+	}{
+		{"3. foo", "3"},
+		{"3.14. bar", "3.14"},
+		{"3.x", "3"},
+		// Fixed-point numbers are decimal only, so a radix number ends at
+		// its period.
+		{"16r1.8", "16r1"},
+		{"2r1.1", "2r1"},
+	}
+	for _, test := range tests {
+		items := lex("test", test.source, Config{})
+		if i := <-items; i.t != TokenNumber || i.v != test.number {
+			t.Errorf("%q: expected number %q but found %s %s", test.source, test.number, tokens[i.t], i)
+		}
+		if i := <-items; i.t != TokenPeriod || i.pos != len(test.number) {
+			t.Errorf("%q: expected '.' at %d but found %s %s at %d", test.source, len(test.number), tokens[i.t], i, i.pos)
+		}
+		for range items {
+		}
+	}
+}
+
+func TestLexTabWidth(t *testing.T) {
+	const source = "\tfoo\n  \tbar\tbaz\n\t\tqux"
+	tests := []struct {
+		tabWidth int
+		cols     []int
+	}{
+		{0, []int{2, 4, 8, 3}},
+		{4, []int{5, 5, 9, 9}},
+		{8, []int{9, 9, 17, 17}},
+	}
+	for _, test := range tests {
+		items := lex("test", source, Config{TabWidth: test.tabWidth})
+		for i, expected := range []item{{TokenIdentifier, "foo", 1, 1, 0}, {TokenIdentifier, "bar", 8, 2, 0}, {TokenIdentifier, "baz", 12, 2, 0}, {TokenIdentifier, "qux", 18, 3, 0}} {
+			expected.col = test.cols[i]
+			if it := <-items; it != expected {
+				t.Errorf("tab width %d: expected %s at %d:%d (%d) but found %s at %d:%d (%d)", test.tabWidth, expected, expected.line, expected.col, expected.pos, it, it.line, it.col, it.pos)
+			}
+		}
+		for range items {
+		}
+	}
+}
+
+func TestLexEmbeddedCapitals(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected item
+	}{
+		{"fooBar", item{TokenIdentifier, "fooBar", 0, 1, 1}},
+		{"fooBar:", item{TokenSmallKeyword, "fooBar:", 0, 1, 1}},
+		{"fooBar2_Baz: x", item{TokenSmallKeyword, "fooBar2_Baz:", 0, 1, 1}},
+		{"FooBar: x", item{TokenCapKeyword, "FooBar:", 0, 1, 1}},
+		{"FooBar x", item{TokenError, "expected ':', found ' '", 0, 1, 1}},
+	}
+	for _, test := range tests {
+		items := lex("test", test.source, Config{})
+		if i := <-items; i != test.expected {
+			t.Errorf("%q: expected %s %s but found %s %s", test.source, tokens[test.expected.t], test.expected, tokens[i.t], i)
+		}
+		for range items {
+		}
+	}
+}
+
+func TestLexRecoverStrings(t *testing.T) {
+	source := "a: 'abc\nfoo bar"
+	expected := []item{
+		{TokenSmallKeyword, "a:", 0, 1, 1},
+		{TokenError, "unclosed string literal", 3, 1, 4},
+		{TokenString, "abc", 3, 1, 4},
+		{TokenIdentifier, "foo", 8, 2, 1},
+		{TokenIdentifier, "bar", 12, 2, 5},
+		{TokenEOF, "", 15, 2, 8},
+	}
+	if items := collect(lex("test", source, Config{Mode: RecoverStrings})); !reflect.DeepEqual(items, expected) {
+		t.Errorf("expected %v but found %v", expected, items)
+	}
+	if items := collect(lex("test", source, Config{})); !reflect.DeepEqual(items, expected[:2]) {
+		t.Errorf("expected %v but found %v", expected[:2], items)
+	}
+	if items := collect(lex("test", "'abc", Config{Mode: RecoverStrings})); !reflect.DeepEqual(items, []item{
+		{TokenError, "unclosed string literal", 0, 1, 1},
+		{TokenString, "abc", 0, 1, 1},
+		{TokenEOF, "", 4, 1, 5},
+	}) {
+		t.Errorf("unexpected items %v", items)
+	}
+}
+
+func TestLexStringTabs(t *testing.T) {
+	source := "'a\tb' foo: '\t\t'"
+	expected := []item{
+		{TokenString, "a\tb", 0, 1, 1},
+		{TokenWarning, "raw tab in string literal; use '\\t'", 2, 1, 3},
+		{TokenSmallKeyword, "foo:", 6, 1, 7},
+		{TokenString, "\t\t", 11, 1, 12},
+		{TokenWarning, "raw tab in string literal; use '\\t'", 12, 1, 13},
+		{TokenWarning, "raw tab in string literal; use '\\t'", 13, 1, 14},
+		{TokenEOF, "", 15, 1, 16},
+	}
+	items := lex("test", source, Config{Mode: CheckStringTabs})
+	for _, e := range expected {
+		if i := <-items; i != e {
+			t.Errorf("expected %s %s at %d but found %s %s at %d", tokens[e.t], e, e.pos, tokens[i.t], i, i.pos)
+		}
+	}
+
+	for i := range lex("test", source, Config{}) {
+		if i.t == TokenWarning {
+			t.Errorf("unexpected warning %s at %d", i, i.pos)
+		}
+	}
+
+	p := parse("test", "'\\ta' '\tb'", Config{Mode: CheckStringTabs})
+	defer p.close()
+	for p.t != TokenEOF {
+		p.next()
+	}
+	if w := []Warning{{7, "raw tab in string literal; use '\\t'"}}; !reflect.DeepEqual(p.warnings, w) {
+		t.Errorf("expected warnings %v but found %v", w, p.warnings)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dropPeriods := func(l Lexeme) (Lexeme, bool) { return l, l.Type != TokenPeriod }
+	upper := func(l Lexeme) (Lexeme, bool) {
+		if l.Type == TokenIdentifier {
+			l.Text = strings.ToUpper(l.Text)
+		}
+		return l, true
+	}
+	next := Filter(Filter(Lex("test", "foo. bar: 3. baz"), dropPeriods), upper)
+	expected := []Lexeme{
+		{TokenIdentifier, "FOO", 0, 3, 1, 1},
+		{TokenSmallKeyword, "bar:", 5, 9, 1, 6},
+		{TokenNumber, "3", 10, 11, 1, 11},
+		{TokenIdentifier, "BAZ", 13, 16, 1, 14},
+		{TokenEOF, "", 16, 16, 1, 17},
+	}
+	for _, e := range expected {
+		if l, ok := next(); !ok || l != e {
+			t.Errorf("expected %s %q at %d but found %s %q at %d", e.Type, e.Text, e.Pos, l.Type, l.Text, l.Pos)
+		}
+	}
+	if l, ok := next(); ok {
+		t.Errorf("expected the end of the lexemes but found %s %q", l.Type, l.Text)
+	}
+}
+
+func TestLexIterator(t *testing.T) {
+	source := "(| x <- 'a' |) x + -3"
+	next := Lex("test", source)
+	var found []Lexeme
+	for l, ok := next(); ok; l, ok = next() {
+		found = append(found, l)
+	}
+	if expected := lexemes(source, collect(lex("test", source, Config{}))); !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v but found %v", expected, found)
+	}
+	if _, ok := next(); ok {
+		t.Errorf("expected the iterator to stay exhausted")
+	}
+
+	// Abandoning an iterator leaves nothing running.
+	before := runtime.NumGoroutine()
+	for n := 0; n < 100; n++ {
+		Lex("test", source)()
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected no goroutines to be left running but found %d more", after-before)
+	}
+}
+
+func TestLexemeEnd(t *testing.T) {
+	source := `x: 'a\'b\n\x41' 16rFF 'unclosed`
+	expected := []string{"x:", `'a\'b\n\x41'`, "16rFF", "", "'unclosed", ""}
+	var raws []string
+	s := NewScannerConfig("test", source, Config{Mode: RecoverStrings, Start: Position{Offset: 100, Line: 5, Column: 1}})
+	for l := s.Next(); ; l = s.Next() {
+		raws = append(raws, source[l.Pos-100:l.End-100])
+		if l.Type == TokenEOF {
+			break
+		}
+	}
+	if !reflect.DeepEqual(raws, expected) {
+		t.Errorf("expected %q but found %q", expected, raws)
+	}
+}
+
+// lexemes returns the lexemes corresponding to items, lexed from source.
+func lexemes(source string, items []item) (all []Lexeme) {
+	for _, i := range items {
+		all = append(all, i.lexeme(source, 0))
+	}
+	return
+}
+
+func TestValidateLexemes(t *testing.T) {
+	sources := []string{
+		"",
+		"#!/usr/bin/env ego\n(| x <- 3. + p = (p) | resend.at: '' Put: -16rFF. ^ x)",
+		"\"doc\" [:a | | t | t: a ** 2.5e3] value: $",
+		"'bad \\q' x. 'tab\there' y",
+		"'unclosed",
+		"a # b\n_ c",
+	}
+	mode := RecoverStrings | CheckStringTabs | ParseComments | Placeholders | HashComments
+	for _, source := range sources {
+		for _, m := range []Mode{0, mode} {
+			if err := ValidateLexemes(lexemes(source, collect(lex("test", source, Config{Mode: m})))); err != nil {
+				t.Errorf("%q, mode %d: unexpected error: %v", source, m, err)
+			}
+		}
+	}
+
+	a := Lexeme{TokenIdentifier, "a", 0, 1, 1, 1}
+	b := Lexeme{TokenIdentifier, "b", 2, 3, 1, 3}
+	eof := Lexeme{TokenEOF, "", 3, 3, 1, 4}
+	tests := []struct {
+		lexemes []Lexeme
+		err     string
+	}{
+		{nil, "empty token stream"},
+		{[]Lexeme{a, b}, "stream ends with identifier rather than EOF or an error"},
+		{[]Lexeme{a, eof, b, eof}, "lexeme 1: EOF before the end of the stream"},
+		{[]Lexeme{a, {TokenNumber, "", 1, 1, 1, 2}, eof}, "lexeme 1: number with empty text"},
+		{[]Lexeme{a, {TokenNumber, "1", 1, 0, 1, 2}, eof}, "lexeme 1: end 0 is before its position 1"},
+		{[]Lexeme{b, a, eof}, "lexeme 1: position 0 is before the previous position 2"},
+		{[]Lexeme{a, {TokenString, "", 1, 3, 1, 2}, eof}, ""},
+		{[]Lexeme{a, {TokenError, "oops", 1, 1, 1, 2}}, ""},
+	}
+	for _, test := range tests {
+		err := ValidateLexemes(test.lexemes)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%v: expected error %q but found %v", test.lexemes, test.err, err)
+		}
+	}
+}
+
+func TestLexLineColumn(t *testing.T) {
+	source := "foo\n  bar: 'héllo' baz\r\n\n'𝔸𝔹' qux"
+	expected := []item{
+		{TokenIdentifier, "foo", 0, 1, 1},
+		{TokenSmallKeyword, "bar:", 6, 2, 3},
+		{TokenString, "héllo", 11, 2, 8},
+		{TokenIdentifier, "baz", 20, 2, 16},
+		{TokenString, "𝔸𝔹", 26, 4, 1},
+		{TokenIdentifier, "qux", 37, 4, 6},
+		{TokenEOF, "", 40, 4, 9},
+	}
+	if items := collect(lex("test", source, Config{})); !reflect.DeepEqual(items, expected) {
+		t.Errorf("expected %v but found %v", expected, items)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	sources := []string{
+		"",
+		"(| x <- 3. + p = (p) | resend.at: 'a\\tb' Put: -16rFF. ^ x)",
+		"a foo: 'unclosed",
+		"\"doc\" [:a | a ** 2.5e3]",
+	}
+	for _, source := range sources {
+		var scanned []Lexeme
+		s := NewScanner("test", source)
+		for l := s.Next(); ; l = s.Next() {
+			scanned = append(scanned, l)
+			if l.Type == TokenEOF || l.Type == TokenError {
+				break
+			}
+		}
+		if lexed := lexemes(source, collect(lex("test", source, Config{}))); !reflect.DeepEqual(scanned, lexed) {
+			t.Errorf("%q: expected %v but found %v", source, lexed, scanned)
+		}
+		end := Lexeme{TokenEOF, "", len(source), len(source), 1, len(source) + 1}
+		for n := 0; n < 2; n++ {
+			if l := s.Next(); l != end {
+				t.Errorf("%q: expected EOF at %d after the end but found %s %q at %d", source, end.Pos, l.Type, l.Text, l.Pos)
+			}
+		}
+	}
+}
+
+// benchmarkSource is a large input for comparing the scanner with lex.
+var benchmarkSource = strings.Repeat("(| x <- 3. + p = (p foo: 'bar' Baz: 16rFF) | resend.at: x Put: -2.5e3. ^ x)\n", 1000)
+
+func BenchmarkLex(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	for n := 0; n < b.N; n++ {
+		for range lex("bench", benchmarkSource, Config{}) {
+		}
+	}
+}
+
+func BenchmarkScanner(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	for n := 0; n < b.N; n++ {
+		s := NewScanner("bench", benchmarkSource)
+		for _, ok := s.next(); ok; _, ok = s.next() {
+		}
+	}
+}
+
+func TestNewScannerConfig(t *testing.T) {
+	source := "#!/usr/bin/env ego\nfoo # comment\n"
+	s := NewScannerConfig("test", source, Config{Mode: SkipShebang | HashComments, Start: Position{Offset: 10, Line: 3, Column: 1}})
+	var scanned []Lexeme
+	for l := s.Next(); ; l = s.Next() {
+		scanned = append(scanned, l)
+		if l.Type == TokenEOF || l.Type == TokenError {
+			break
+		}
+	}
+	expected := []Lexeme{{TokenIdentifier, "foo", 29, 32, 4, 1}, {TokenEOF, "", 43, 43, 5, 1}}
+	if !reflect.DeepEqual(scanned, expected) {
+		t.Errorf("expected %v but found %v", expected, scanned)
+	}
+}
+
+func TestNewScannerReader(t *testing.T) {
+	source := "(| héllo = '𝔸𝔹\\t' |) héllo: 16rFF. ^ 'é'"
+	// One byte per read splits every multibyte rune between reads.
+	s, err := NewScannerReader("test", bufio.NewReaderSize(iotest.OneByteReader(strings.NewReader(source)), 16))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := NewScanner("test", source)
+	for {
+		l, expected := s.Next(), want.Next()
+		if l != expected {
+			t.Fatalf("expected %s %q at %d but found %s %q at %d", expected.Type, expected.Text, expected.Pos, l.Type, l.Text, l.Pos)
+		}
+		if l.Type == TokenEOF || l.Type == TokenError {
+			break
+		}
+	}
+
+	if _, err := NewScannerReader("test", iotest.ErrReader(io.ErrUnexpectedEOF)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected %v but found %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestTokenString(t *testing.T) {
+	tests := []struct {
+		t    Token
+		name string
+	}{
+		{TokenIdentifier, "identifier"},
+		{TokenLeftArrow, "<-"},
+		{TokenEOF, "EOF"},
+		{literals_start, "Token(3)"},
+		{Token(-1), "Token(-1)"},
+		{Token(len(tokens)), fmt.Sprintf("Token(%d)", len(tokens))},
+	}
+	for _, test := range tests {
+		if name := test.t.String(); name != test.name {
+			t.Errorf("expected %q but found %q", test.name, name)
+		}
+	}
+}
@@ -1,20 +1,95 @@
 package ego
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Mode value is a set of flags (or 0) that control optional lexer and
+// parser functionality.
+type Mode uint
+
+const (
+	SkipShebang       Mode = 1 << iota // skip a leading "#!" line
+	Placeholders                       // treat a lone '_' as a placeholder, not an identifier
+	RecoverStrings                     // end an unclosed string at the end of its line and continue
+	CheckStringTabs                    // warn about raw tabs in string literals
+	ParseComments                      // keep comments, which are otherwise discarded
+	NoDirectedResends                  // report resends directed to a parent, such as "parent.foo"
+	HashComments                       // treat '#' as starting a comment running to the end of the line
+	IndentTokens                       // report changes of indentation as tokens, which the parser does not accept
+	LowercaseKeywords                  // let lowercase keywords continue a selector, as in "at: 1 put: 2"
+)
+
+// An Error is a syntax error found at a byte offset in the input.
+type Error struct {
+	Pos int    // byte offset of the error
+	Msg string // description of the error
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%d: %s", e.Pos, e.Msg) }
+
+// An ErrorHandler is called with the byte offset and message of each syntax
+// error. A handler may stop parsing by calling panic(ErrAbort).
+type ErrorHandler func(pos int, msg string)
+
+// ErrAbort stops parsing when an ErrorHandler panics with it.
+var ErrAbort = errors.New("parsing aborted")
+
+// A Config controls optional lexer and parser functionality. The zero value
+// is the default configuration.
+type Config struct {
+	Mode        Mode
+	Error       ErrorHandler // if nil, errors are collected by the parser
+	Warn        ErrorHandler // if nil, warnings are collected by the parser
+	TabWidth    int          // if positive, expand tabs to this width in columns
+	MaxKeywords int          // parts allowed in a keyword message; 0 means DefaultMaxKeywords, negative means no limit
+
+	// Start is the position of the start of the input in a host file in
+	// which it is embedded, such as a template. Items and errors are then
+	// positioned in the host file. The zero value means the input is not
+	// embedded.
+	Start Position
+}
+
+// DefaultMaxKeywords is the number of parts allowed in a keyword message
+// unless configured otherwise.
+const DefaultMaxKeywords = 32
+
 type parser struct {
 	item
+	mode               Mode
 	peekItem, nextItem <-chan item
 	pushBack           chan<- item
 	quit               chan<- struct{}
+	handler            ErrorHandler
+	errors             []error
+	warn               ErrorHandler
+	warnings           []Warning
+	comments           []item
+	eofComments        int  // index in comments of those before EOF, or -1 until it is reached
+	inSlots            bool // whether '|' ends a slot list rather than being an operator
+	maxKeywords        int
+	input              string // for the source text of strings
+	offset             int    // byte offset of the input in its host file
+	aborted            bool   // whether a handler aborted on the first item
 }
 
-func parse(name, input string) *parser {
+func parse(name, input string, c Config) (p *parser) {
 	peek, next, push := make(chan item), make(chan item), make(chan item)
 	quit := make(chan struct{})
-	defer close(quit)
 
 	go func() {
-		items := lex(name, input)
-		i := <-items
+		items := lex(name, input, c)
+		recv := func() item {
+			if i, ok := <-items; ok {
+				return i
+			}
+			pos := c.start().after(input, c.TabWidth)
+			return item{TokenEOF, "", pos.Offset, pos.Line, pos.Column}
+		}
+		i := recv()
 		backup, hasBackup := i, false
 		for {
 			select {
@@ -23,27 +98,70 @@ func parse(name, input string) *parser {
 				if hasBackup {
 					i, hasBackup = backup, false
 				} else {
-					i = <-items
+					i = recv()
 				}
 			case item := <-push:
 				backup, hasBackup = i, true
 				i = item
 			case <-quit:
+				// Let the lexer run to completion rather than block forever
+				// on items that will never be received.
+				for range items {
+				}
 				return
 			}
 		}
 	}()
 
-	p := &parser{peekItem: peek, nextItem: next, pushBack: push, quit: quit}
+	p = &parser{mode: c.Mode, peekItem: peek, nextItem: next, pushBack: push, quit: quit, handler: c.Error, warn: c.Warn, maxKeywords: c.MaxKeywords, eofComments: -1, input: input, offset: c.start().Offset}
+	if p.maxKeywords == 0 {
+		p.maxKeywords = DefaultMaxKeywords
+	}
+	if p.handler == nil {
+		p.handler = func(pos int, msg string) { p.errors = append(p.errors, &Error{pos, msg}) }
+	}
+	if p.warn == nil {
+		p.warn = func(pos int, msg string) { p.warnings = append(p.warnings, Warning{pos, msg}) }
+	}
+	// The first item may already be reported to a handler that aborts.
+	defer func() {
+		if r := recover(); r == ErrAbort {
+			p.aborted = true
+		} else if r != nil {
+			p.close()
+			panic(r)
+		}
+	}()
 	p.next()
 	return p
 }
 
-func (p *parser) next()       { p.item = <-p.nextItem }
-func (p *parser) peek() item  { return <-p.peekItem }
-func (p *parser) atEOF() bool { return p.peek().t == tokenEOF }
+// close stops the goroutine feeding items to the parser, and the lexer
+// behind it, even if not all items have been consumed.
+func (p *parser) close() { close(p.quit) }
+
+func (p *parser) peek() item { return <-p.peekItem }
+
+// next advances to the next item, reporting any lexical errors and warnings
+// and collecting any comments on the way.
+func (p *parser) next() {
+	n := len(p.comments)
+	for p.item = <-p.nextItem; p.t == TokenError || p.t == TokenWarning || p.t == TokenComment; p.item = <-p.nextItem {
+		switch p.t {
+		case TokenError:
+			p.error(p.pos, p.v)
+		case TokenWarning:
+			p.warn(p.pos, p.v)
+		case TokenComment:
+			p.comments = append(p.comments, p.item)
+		}
+	}
+	if p.t == TokenEOF && p.eofComments < 0 {
+		p.eofComments = n
+	}
+}
 
-func (p *parser) expect(t token) int {
+func (p *parser) expect(t Token) int {
 	pos := p.pos
 	if p.t != t {
 		p.errorExpected(pos, "'"+tokens[t]+"'")
@@ -52,11 +170,19 @@ func (p *parser) expect(t token) int {
 	return pos
 }
 
-func (p *parser) error(pos int, msg string) {
-	// TODO
-	panic(msg)
+// expectEnd is like expect, but returns the offset just past the item, or,
+// if the item is missing, the offset of the one found instead.
+func (p *parser) expectEnd(t Token) int {
+	end := p.pos
+	if p.t == t {
+		end += len(p.v)
+	}
+	p.expect(t)
+	return end
 }
 
+func (p *parser) error(pos int, msg string) { p.handler(pos, msg) }
+
 func (p *parser) errorExpected(pos int, msg string) {
 	msg = "expected " + msg
 	if pos == p.pos {
@@ -70,63 +196,179 @@ func (p *parser) errorExpected(pos int, msg string) {
 
 var implicitSelf expr = nil
 
-func (p *parser) parseExpr() expr {
-	if p.next(); p.t == tokenEOF {
-		return nil
+// Parse parses input, a Self program, and returns its syntax tree and any
+// syntax errors. Parsing continues after an error, so the tree may be
+// incomplete, but it is never nil.
+func Parse(name, input string) (Node, []error) {
+	n, errs, _ := ParseConfig(name, input, Config{})
+	return n, errs
+}
+
+// ParseConfig is like Parse, but parses input as configured by c, and also
+// returns any warnings. Errors and warnings passed to a handler in c are not
+// returned. If the error handler aborts, the tree is nil.
+func ParseConfig(name, input string, c Config) (Node, []error, []Warning) {
+	p := parse(name, input, c)
+	defer p.close()
+	if s := p.parseProgram(); s != nil {
+		return s, p.errors, p.warnings
+	}
+	return nil, p.errors, p.warnings
+}
+
+// ParseObject is like Parse, but requires input to consist of a single
+// object literal, as in a file defining an object, and returns that object.
+func ParseObject(name, input string) (Node, []error) {
+	p := parse(name, input, Config{})
+	defer p.close()
+	s := p.parseProgram()
+	var o *object
+	if len(s.exprs) > 0 {
+		o, _ = s.exprs[0].(*object)
+	}
+	if o == nil {
+		p.error(spanStart(s), "expected object literal")
+		return nil, p.errors
+	}
+	if len(s.exprs) > 1 {
+		pos := o.pos
+		if e := s.exprs[1]; e != nil {
+			pos = spanStart(e)
+		}
+		p.error(pos, "expected a single object literal")
+	}
+	return o, p.errors
+}
+
+// parseProgram parses the statements making up the whole input, stopping
+// early, and returning nil, if the error handler aborts. In ParseComments
+// mode, the comments preceding the first statement become the program's doc
+// comment, and those following the last one its trailer.
+func (p *parser) parseProgram() (s *sequence) {
+	var doc []string
+	for _, c := range p.comments {
+		doc = append(doc, commentText(c.v))
+	}
+	var list []expr
+	if p.parseTopLevel(func(e expr) { list = append(list, e) }) {
+		var trailer []string
+		if p.eofComments >= len(doc) {
+			for _, c := range p.comments[p.eofComments:] {
+				trailer = append(trailer, commentText(c.v))
+			}
+		}
+		s = &sequence{list, strings.Join(doc, "\n"), strings.Join(trailer, "\n")}
+	}
+	return
+}
+
+// parseTopLevel parses the statements of a program, passing each to f as
+// soon as it is complete. It reports whether parsing ran to the end of the
+// input rather than being stopped by ErrAbort.
+func (p *parser) parseTopLevel(f func(expr)) (ok bool) {
+	if p.aborted {
+		return false
+	}
+	defer func() {
+		if r := recover(); r != nil && r != ErrAbort {
+			panic(r)
+		}
+	}()
+	p.statements(TokenEOF, f)
+	if p.t != TokenEOF {
+		p.errorExpected(p.pos, "end of input")
 	}
+	return true
+}
+
+// ParseWithCallback parses input as a program, calling onStmt with each
+// top-level statement as soon as it has been parsed, so that a caller can
+// evaluate a program while it is being read. It returns any syntax errors.
+func ParseWithCallback(name, input string, onStmt func(expr)) []error {
+	p := parse(name, input, Config{})
+	defer p.close()
+	p.parseTopLevel(onStmt)
+	return p.errors
+}
+
+// parseExpr parses a complete expression starting at the current item.
+func (p *parser) parseExpr() expr {
 	return p.parsePrimaryExpr()
 }
 
-func isKeyword(t token) bool { return t == tokenSmallKeyword }
+func isKeyword(t Token) bool    { return t == TokenSmallKeyword }
+func isIdentifier(t Token) bool { return t == TokenIdentifier }
 
-func isOperator(t token) bool {
-	return t == tokenOperator || t == tokenEqual || t == tokenLeftArrow // TODO || t == tokenTilde?
+func isOperator(t Token) bool {
+	return t == TokenOperator || t == TokenEqual || t == TokenLeftArrow || t == TokenStar // TODO || t == TokenTilde?
 }
 
+// isOperator reports whether the current item is a binary operator. A '|'
+// is one except where it can end a slot list.
+func (p *parser) isOperator() bool {
+	return isOperator(p.t) || p.t == TokenBar && !p.inSlots
+}
+
+// maybeOperator reports whether the current item can start a binary message.
+// A negative number such as "-1" following an operand is the binary message
+// '-' with a positive argument.
 func (p *parser) maybeOperator() bool {
-	i := p.peek()
-	return isOperator(i.t) || i.t == tokenNumber && i.v[0] == '-'
+	return p.isOperator() || p.t == TokenNumber && strings.HasPrefix(p.v, "-")
 }
 
 func (p *parser) parsePrimaryExpr() (e expr) {
+	pos := p.pos
 	d := p.parseDelegate(isKeyword)
-	if p.t == tokenSmallKeyword {
+	if p.t == TokenSmallKeyword {
 		e = implicitSelf
+	} else if e = p.parseBinary(); p.t != TokenSmallKeyword {
+		return
 	} else {
-		if e = p.parseBinary(); e == nil {
-			return nil
-		}
-		if p.next(); p.t == tokenEOF {
-			// TODO error
-			return nil
-		}
+		pos = p.pos
 	}
-	if p.t == tokenSmallKeyword {
-		kw := []string{p.v}
-		arg := p.parseExpr()
-		if arg == nil {
-			return nil
-		}
-		args := []expr{arg}
-		for p.next(); p.t == tokenCapKeyword; p.next() {
-			kw = append(kw, p.v)
-			if arg = p.parseExpr(); arg == nil {
-				return nil
-			}
-			args = append(args, arg)
-		}
-		if p.t == tokenEOF {
-			// TODO error
-			return nil
-		}
-		e = &keyword{e, kw, args, d}
+	// Lowercase keywords start a new message, so "a foo: b bar: c" is
+	// "a foo: (b bar: c)"; only capitalized keywords continue the selector,
+	// unless LowercaseKeywords lets any keyword continue it.
+	kw := []string{p.v}
+	p.next()
+	args := []expr{p.parseArgument(kw[0])}
+	for p.t == TokenCapKeyword || p.t == TokenSmallKeyword && p.mode&LowercaseKeywords != 0 {
+		kw = append(kw, p.v)
+		p.next()
+		args = append(args, p.parseArgument(kw[len(kw)-1]))
 	}
-	return
+	if p.maxKeywords > 0 && len(kw) > p.maxKeywords {
+		p.error(pos, fmt.Sprintf("keyword message has %d parts, more than the limit of %d", len(kw), p.maxKeywords))
+	}
+	return &keyword{e, kw, args, d, pos}
 }
 
-func (p *parser) parseDelegate(expectNext func(token) bool) string {
-	if p.t == tokenDelegate || p.t == tokenResend {
+// parseArgument parses the argument of the keyword kw. A missing argument is
+// reported, leaving the statement's terminator for the statement parser. In
+// LowercaseKeywords mode, arguments cannot be keyword messages, since any
+// keyword continues the selector.
+func (p *parser) parseArgument(kw string) expr {
+	lenient := p.mode&LowercaseKeywords != 0
+	switch {
+	case p.t == TokenPeriod, p.t == TokenEOF, p.t == TokenRightParen, p.t == TokenRightBracket, p.t == TokenCapKeyword,
+		lenient && p.t == TokenSmallKeyword:
+		p.error(p.pos, "missing argument for '"+kw+"'")
+		return nil
+	case lenient:
+		return p.parseBinary()
+	}
+	return p.parseExpr()
+}
+
+// parseDelegate parses the delegate of a resend if it is followed by a token
+// for which expectNext is true. In Self every delegate makes a resend: the
+// undirected "resend.foo" or one directed to a parent slot, "parent.foo".
+func (p *parser) parseDelegate(expectNext func(Token) bool) string {
+	if p.t == TokenDelegate || p.t == TokenResend {
 		if expectNext(p.peek().t) {
+			if p.t == TokenDelegate && p.mode&NoDirectedResends != 0 {
+				p.error(p.pos, "directed resend to '"+p.v[:len(p.v)-1]+"' is not allowed; use 'resend'")
+			}
 			d := p.v[:len(p.v)-1]
 			p.next()
 			return d
@@ -136,59 +378,269 @@ func (p *parser) parseDelegate(expectNext func(token) bool) string {
 }
 
 func (p *parser) parseBinary() (e expr) {
+	dpos := p.pos
 	d := p.parseDelegate(isOperator)
-	if isOperator(p.t) {
+	if p.isOperator() {
 		e = implicitSelf
 	} else {
-		if p.t == tokenLeftParen {
-			if p.atEOF() {
-				// TODO error
-				return nil
-			}
-		}
-		if e = p.parseUnary(); e == nil {
-			return nil
-		}
-		if p.atEOF() {
-			// TODO error
-			return nil
-		}
-		// TODO method candidate?
+		e = p.parseUnary()
 	}
 	prev := ""
 	for p.maybeOperator() {
-		op := p.v
-		if !isOperator(p.t) {
+		op, pos := p.v, p.pos
+		if p.isOperator() {
+			p.next()
+		} else {
+			// Split the negative number into the operator and its argument;
+			// multi-character operators such as "<=" never take this path.
 			op = op[:1]
+			p.v, p.pos = p.v[1:], p.pos+1
+		}
+		if p.t == TokenEOF {
+			p.error(pos, "missing right operand for '"+op+"'")
+			return
 		}
 		if len(prev) != 0 && prev != op {
-			// TODO syntax error
-			return nil
+			// Report the error but keep parsing left to right, so that
+			// neither operand is lost.
+			p.error(pos, "mixed binary operators need parentheses")
 		}
 		prev = op
 		var arg expr
-		switch p.next(); p.t {
-		case tokenEOF:
-			// TODO error
-			return nil
-		case tokenSmallKeyword:
+		if p.t == TokenSmallKeyword {
 			arg = p.parseExpr()
-		default:
+		} else {
 			arg = p.parseUnary()
 		}
-		if arg == nil {
-			return nil
-		}
-		e = &binary{e, op, arg, d}
-		if p.atEOF() {
-			// TODO error
-			return nil
+		if d != "" {
+			pos = dpos
 		}
+		e = &binary{e, op, arg, d, pos}
+		d = ""
 	}
 	return
 }
 
 func (p *parser) parseUnary() (e expr) {
-	// TODO
+	pos := p.pos
+	d := p.parseDelegate(isIdentifier)
+	if p.t == TokenIdentifier {
+		e = &unary{implicitSelf, p.v, d, pos}
+		p.next()
+	} else {
+		e = p.parseReceiver()
+	}
+	for p.t == TokenIdentifier {
+		e = &unary{e, p.v, "", p.pos}
+		p.next()
+	}
 	return
 }
+
+// parseReceiver parses the explicit receiver of a message.
+func (p *parser) parseReceiver() expr {
+	switch p.t {
+	case TokenLeftParen:
+		return p.parseObject()
+	case TokenLeftBracket:
+		return p.parseBlock()
+	case TokenSelf:
+		e := &selfExpr{p.pos}
+		p.next()
+		return e
+	case TokenNumber:
+		e := &numberLit{p.v, p.pos}
+		p.next()
+		return e
+	case TokenPlaceholder:
+		e := &placeholder{p.pos}
+		p.next()
+		return e
+	case TokenString:
+		e := &stringLit{p.v, p.pos, p.pos + len(p.raw(p.input, p.offset))}
+		p.next()
+		return e
+	case TokenCaret:
+		p.error(p.pos, "return is only allowed at the start of a statement")
+		p.next()
+		return p.parseUnary()
+	}
+	p.errorExpected(p.pos, "expression")
+	return nil
+}
+
+// ParseUnaryExpr parses a unary expression, a receiver followed by any
+// number of unary messages, at the start of input, for embedding expressions
+// in another grammar. It returns the expression, the byte offset of the first
+// token following it, at which the host grammar can resume, and any syntax
+// errors. Binary and keyword messages are left unparsed.
+func ParseUnaryExpr(name, input string) (e expr, end int, errs []error) {
+	return parsePartial(name, input, (*parser).parseUnary)
+}
+
+// ParseBinaryExpr is like ParseUnaryExpr, but parses a binary expression,
+// leaving only keyword messages unparsed.
+func ParseBinaryExpr(name, input string) (e expr, end int, errs []error) {
+	return parsePartial(name, input, (*parser).parseBinary)
+}
+
+func parsePartial(name, input string, parseExpr func(*parser) expr) (expr, int, []error) {
+	p := parse(name, input, Config{})
+	defer p.close()
+	e := parseExpr(p)
+	return e, p.pos, p.errors
+}
+
+// parseStatements parses a list of expressions separated by periods, ending
+// before end.
+func (p *parser) parseStatements(end Token) (list []expr) {
+	p.statements(end, func(e expr) { list = append(list, e) })
+	return
+}
+
+// statements is like parseStatements, but passes each expression to f
+// rather than collecting them.
+func (p *parser) statements(end Token, f func(expr)) {
+	for p.t != end && p.t != TokenEOF {
+		if p.t == TokenPeriod {
+			p.error(p.pos, "empty statement")
+			p.next()
+			continue
+		}
+		f(p.parseStatement())
+		if p.t != TokenPeriod {
+			break
+		}
+		p.next()
+	}
+}
+
+// setInSlots restores p.inSlots on leaving a slot list or literal.
+func (p *parser) setInSlots(inSlots bool) { p.inSlots = inSlots }
+
+// parseStatement parses an expression, or a return of one, "^expr". A
+// missing return value is reported and recorded as nil.
+func (p *parser) parseStatement() expr {
+	if p.t == TokenCaret {
+		pos := p.pos
+		p.next()
+		switch p.t {
+		case TokenPeriod, TokenEOF, TokenRightParen, TokenRightBracket:
+			p.error(p.pos, "missing expression after '^'")
+			return &returnStmt{nil, pos}
+		}
+		return &returnStmt{p.parseExpr(), pos}
+	}
+	return p.parseExpr()
+}
+
+// parseObject parses an object literal, "(| slots | statements)", in which
+// both the slot list and the statements may be omitted.
+func (p *parser) parseObject() *object {
+	defer p.setInSlots(p.inSlots)
+	p.inSlots = false
+	o := &object{pos: p.expect(TokenLeftParen)}
+	if p.t == TokenBar || p.t == TokenOperator && p.v == "||" {
+		o.slots = p.parseSlots()
+	}
+	o.body = p.parseStatements(TokenRightParen)
+	o.end = p.expectEnd(TokenRightParen)
+	return o
+}
+
+// parseBlock parses a block literal, "[:arg | | slots | statements]", in
+// which the arguments, slot list and statements may all be omitted.
+func (p *parser) parseBlock() *block {
+	defer p.setInSlots(p.inSlots)
+	p.inSlots = false
+	b := &block{pos: p.expect(TokenLeftBracket)}
+	for p.t == TokenArgumentName {
+		b.slots = append(b.slots, &slot{name: p.v[1:], kind: argumentSlot, pos: p.pos})
+		if p.next(); p.t != TokenArgumentName {
+			p.expect(TokenBar)
+		}
+	}
+	if p.t == TokenBar || p.t == TokenOperator && p.v == "||" {
+		b.slots = append(b.slots, p.parseSlots()...)
+	}
+	b.body = p.parseStatements(TokenRightBracket)
+	b.end = p.expectEnd(TokenRightBracket)
+	return b
+}
+
+// parseSlots parses a slot list, "| slot. slot |". The slots are separated
+// by periods, and the last may be followed by one.
+func (p *parser) parseSlots() (slots []*slot) {
+	if p.t == TokenOperator { // "||" is lexed as a single operator
+		p.next()
+		return nil
+	}
+	defer p.setInSlots(p.inSlots)
+	p.inSlots = true
+	p.expect(TokenBar)
+	for p.t != TokenBar && p.t != TokenEOF {
+		slots = append(slots, p.parseSlot())
+		if p.t != TokenPeriod {
+			break
+		}
+		p.next()
+	}
+	p.expect(TokenBar)
+	return
+}
+
+// parseSlot parses an argument slot ":name", a data slot "name = expr", an
+// assignable slot "name <- expr" or "name", or a method slot such as
+// "+ arg = (...)" or "at: i Put: v = (...)". A '*' after the name of a data
+// or assignable slot makes it a parent slot.
+func (p *parser) parseSlot() *slot {
+	s := &slot{pos: p.pos}
+	switch p.t {
+	case TokenArgumentName:
+		s.name, s.kind = p.v[1:], argumentSlot
+		p.next()
+		return s
+	case TokenIdentifier:
+		s.name = p.v
+		if p.next(); p.t == TokenStar {
+			s.parent = true
+			p.next()
+		}
+		switch p.t {
+		case TokenEqual:
+			s.kind = dataSlot
+		case TokenLeftArrow:
+			s.kind = assignableSlot
+		default:
+			s.kind = assignableSlot
+			return s
+		}
+		p.next()
+	case TokenOperator, TokenStar:
+		s.name = p.v
+		p.next()
+		s.params = []string{p.v}
+		p.expect(TokenIdentifier)
+		p.expect(TokenEqual)
+	case TokenSmallKeyword:
+		s.name = p.v
+		if p.next(); p.t == TokenIdentifier {
+			s.params = []string{p.v}
+			p.next()
+		}
+		for p.t == TokenCapKeyword {
+			s.name += p.v
+			p.next()
+			if s.params != nil {
+				s.params = append(s.params, p.v)
+				p.expect(TokenIdentifier)
+			}
+		}
+		p.expect(TokenEqual)
+	default:
+		p.errorExpected(p.pos, "slot")
+		return s
+	}
+	s.value = p.parseExpr()
+	return s
+}
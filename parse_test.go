@@ -0,0 +1,1064 @@
+package ego
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseExpr(t *testing.T, source string) expr {
+	p := parse("test", source, Config{})
+	defer p.close()
+	e := p.parseExpr()
+	if p.t != TokenEOF {
+		t.Errorf("%q: expected EOF but found %s (%s)", source, tokens[p.t], p.item)
+	}
+	for _, err := range p.errors {
+		t.Errorf("%q: unexpected error: %v", source, err)
+	}
+	return clearPos(e)
+}
+
+// clearPos zeroes the positions recorded in e so that it can be compared
+// with a tree built by hand.
+func clearPos(e expr) expr {
+	mapPos(e, func(int) int { return 0 })
+	return e
+}
+
+func send(receiver expr, selector string) *unary { return &unary{receiver, selector, "", 0} }
+
+func TestParseMultiCharacterOperators(t *testing.T) {
+	for _, op := range []string{"<=", "->", ">=", "~=", "==", "&&", "<<", "+=", "<-"} {
+		source := "a " + op + " b"
+		e := parseExpr(t, source)
+		expected := &binary{send(nil, "a"), op, send(nil, "b"), "", 0}
+		if !reflect.DeepEqual(e, expected) {
+			t.Errorf("%q: expected %#v but found %#v", source, expected, e)
+		}
+	}
+}
+
+func TestParseComma(t *testing.T) {
+	ab := &binary{send(nil, "a"), ",", send(nil, "b"), "", 0}
+	for _, test := range []struct {
+		source   string
+		expected expr
+	}{
+		{"a , b", ab},
+		{"a,b", ab},
+		{"a , b , c", &binary{ab, ",", send(nil, "c"), "", 0}},
+	} {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseModulo(t *testing.T) {
+	mod := &binary{send(nil, "a"), "%", send(nil, "b"), "", 0}
+	for _, test := range []struct {
+		source   string
+		expected expr
+	}{
+		{"a % b", mod},
+		{"a%b", mod},
+		{"a % b % c", &binary{mod, "%", send(nil, "c"), "", 0}},
+		{"7 % 2", &binary{&numberLit{"7", 0}, "%", &numberLit{"2", 0}, "", 0}},
+		{"a % b foo: c", &keyword{mod, []string{"foo:"}, []expr{send(nil, "c")}, "", 0}},
+	} {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+// Self has no cascades: ';' is an ordinary binary operator, so what would be
+// a cascade in Smalltalk sends ';' to the result of the first message.
+func TestParseNoCascades(t *testing.T) {
+	afoo := send(send(nil, "a"), "foo")
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"a foo; bar", &binary{afoo, ";", send(nil, "bar"), "", 0}},
+		{"a ; b ; c", &binary{&binary{send(nil, "a"), ";", send(nil, "b"), "", 0}, ";", send(nil, "c"), "", 0}},
+		{"c add: 1; yourself", &keyword{send(nil, "c"), []string{"add:"}, []expr{&binary{&numberLit{"1", 0}, ";", send(nil, "yourself"), "", 0}}, "", 0}},
+		// The argument of ';' may be a keyword message to implicit self.
+		{"c add: 1; add: 2", &keyword{send(nil, "c"), []string{"add:"}, []expr{&binary{&numberLit{"1", 0}, ";",
+			&keyword{nil, []string{"add:"}, []expr{&numberLit{"2", 0}}, "", 0}, "", 0}}, "", 0}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseNumberText(t *testing.T) {
+	for _, source := range []string{"0", "007", "-17", "16rFF", "16rff", "36rZz", "1.50", "1.5e3", "1.5E+03", "6.022e-23"} {
+		e := parseExpr(t, source)
+		if n, ok := e.(*numberLit); !ok || n.text != source {
+			t.Errorf("%q: expected the number %s but found %#v", source, source, e)
+		}
+	}
+}
+
+func TestParseNegativeNumbers(t *testing.T) {
+	aMinus1 := &binary{send(nil, "a"), "-", &numberLit{"1", 0}, "", 0}
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"-1", &numberLit{"-1", 0}},
+		{"a-1", aMinus1},
+		{"a -1", aMinus1},
+		{"a - 1", aMinus1},
+		{"a foo: -2.5e3", &keyword{send(nil, "a"), []string{"foo:"}, []expr{&numberLit{"-2.5e3", 0}}, "", 0}},
+		{"-1 abs", send(&numberLit{"-1", 0}, "abs")},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseBar(t *testing.T) {
+	ab := &binary{send(nil, "a"), "|", send(nil, "b"), "", 0}
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"(| a |)", &object{slots: []*slot{{name: "a", kind: assignableSlot}}}},
+		{"a | b", ab},
+		{"a | b | c", &binary{ab, "|", send(nil, "c"), "", 0}},
+		{"| b", &binary{nil, "|", send(nil, "b"), "", 0}},
+		{"(a | b)", &object{body: []expr{ab}}},
+		{"(| x = a | b)", &object{slots: []*slot{{name: "x", kind: dataSlot, value: send(nil, "a")}}, body: []expr{send(nil, "b")}}},
+		{"(| x = (a | b) | x)", &object{slots: []*slot{{name: "x", kind: dataSlot, value: &object{body: []expr{ab}}}}, body: []expr{send(nil, "x")}}},
+		{"(| x = [a | b] |)", &object{slots: []*slot{{name: "x", kind: dataSlot, value: &block{body: []expr{ab}}}}}},
+		{"[:a | a | b]", &block{slots: []*slot{{name: "a", kind: argumentSlot}}, body: []expr{ab}}},
+		{"[| a | a | b]", &block{slots: []*slot{{name: "a", kind: assignableSlot}}, body: []expr{ab}}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseOperatorChain(t *testing.T) {
+	e := parseExpr(t, "a -> b -> c")
+	first := &binary{send(nil, "a"), "->", send(nil, "b"), "", 0}
+	expected := &binary{first, "->", send(nil, "c"), "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+}
+
+func TestMaybeOperator(t *testing.T) {
+	tests := []struct {
+		item item
+		ok   bool
+	}{
+		{item{TokenOperator, "+", 0, 1, 1}, true},
+		{item{TokenNumber, "-1", 0, 1, 1}, true},
+		{item{TokenNumber, "1", 0, 1, 1}, false},
+		{item{TokenNumber, "", 0, 1, 1}, false},
+		{item{TokenEOF, "", 0, 1, 1}, false},
+		{item{TokenError, "", 0, 1, 1}, false},
+	}
+	for _, test := range tests {
+		p := &parser{item: test.item}
+		if ok := p.maybeOperator(); ok != test.ok {
+			t.Errorf("%s %q: expected %t but found %t", test.item.t, test.item.v, test.ok, ok)
+		}
+	}
+}
+
+func TestParseMissingOperand(t *testing.T) {
+	tests := []struct {
+		source string
+		err    Error
+	}{
+		{"a +", Error{2, "missing right operand for '+'"}},
+		{"a + b -", Error{6, "missing right operand for '-'"}},
+		{"a \\", Error{2, "missing right operand for '\\'"}},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{})
+		p.parseExpr()
+		p.close()
+		if len(p.errors) != 1 {
+			t.Errorf("%q: expected 1 error but found %v", test.source, p.errors)
+		} else if err := p.errors[0].(*Error); *err != test.err {
+			t.Errorf("%q: expected %v but found %v", test.source, &test.err, err)
+		}
+	}
+
+	e := parseExpr(t, "a + b")
+	expected := &binary{send(nil, "a"), "+", send(nil, "b"), "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+}
+
+func TestParseMixedOperators(t *testing.T) {
+	tests := []struct {
+		source string
+		errs   []error
+		format string
+	}{
+		{"2 + 3 * 4", []error{&Error{6, "mixed binary operators need parentheses"}}, "(2 + 3) * 4"},
+		{"x: 1 + 2 * 3. y", []error{&Error{9, "mixed binary operators need parentheses"}}, "x: (1 + 2) * 3. y"},
+		{"(a#0!)", []error{
+			&Error{4, "mixed binary operators need parentheses"},
+			&Error{5, "expected expression, found ')'"},
+		}, ""},
+	}
+	for _, test := range tests {
+		n, errs := Parse("test", test.source)
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q: expected errors %v but found %v", test.source, test.errs, errs)
+		}
+		if s := Format(n); test.format != "" && s != test.format {
+			t.Errorf("%q: expected %q but found %q", test.source, test.format, s)
+		}
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	source := "a foo: ) bar"
+	p := parse("test", source, Config{})
+	p.parseProgram()
+	p.close()
+	if len(p.errors) != 2 {
+		t.Errorf("expected 2 errors but found %v", p.errors)
+	}
+
+	var errs []Error
+	abort := func(pos int, msg string) {
+		errs = append(errs, Error{pos, msg})
+		panic(ErrAbort)
+	}
+	p = parse("test", source, Config{Error: abort})
+	if e := p.parseProgram(); e != nil {
+		t.Errorf("expected no expression but found %#v", e)
+	}
+	p.close()
+	if expected := []Error{{7, "missing argument for 'foo:'"}}; !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v but found %v", expected, errs)
+	}
+	if len(p.errors) != 0 {
+		t.Errorf("expected no collected errors but found %v", p.errors)
+	}
+}
+
+func TestParsePlaceholder(t *testing.T) {
+	p := parse("test", "_ foo: _bar", Config{Mode: Placeholders})
+	defer p.close()
+	e := clearPos(p.parseExpr())
+	expected := &keyword{&placeholder{}, []string{"foo:"}, []expr{send(nil, "_bar")}, "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+	if len(p.errors) != 0 {
+		t.Errorf("unexpected errors: %v", p.errors)
+	}
+
+	e = parseExpr(t, "_ foo: _bar")
+	expected = &keyword{send(nil, "_"), []string{"foo:"}, []expr{send(nil, "_bar")}, "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+}
+
+func TestParseKeywordBinaryArguments(t *testing.T) {
+	b := &binary{send(nil, "b"), "+", send(nil, "c"), "", 0}
+	d := &binary{send(nil, "d"), "*", send(nil, "e"), "", 0}
+
+	e := parseExpr(t, "a foo: b + c Bar: d * e")
+	expected := &keyword{send(nil, "a"), []string{"foo:", "Bar:"}, []expr{b, d}, "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+
+	// A lowercase keyword starts a new message in the argument.
+	e = parseExpr(t, "a foo: b + c bar: d * e")
+	inner := &keyword{b, []string{"bar:"}, []expr{d}, "", 0}
+	expected = &keyword{send(nil, "a"), []string{"foo:"}, []expr{inner}, "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+}
+
+func TestParseParenthesizedKeywordReceiver(t *testing.T) {
+	e := parseExpr(t, "(a foo: b) bar: c")
+	inner := &keyword{send(nil, "a"), []string{"foo:"}, []expr{send(nil, "b")}, "", 0}
+	expected := &keyword{&object{body: []expr{inner}}, []string{"bar:"}, []expr{send(nil, "c")}, "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+}
+
+func TestParseKeywordResend(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"resend.at: 1 Put: 2", &keyword{nil, []string{"at:", "Put:"}, []expr{&numberLit{"1", 0}, &numberLit{"2", 0}}, "resend", 0}},
+		{"resend.foo", &unary{nil, "foo", "resend", 0}},
+		{"parent.at: i", &keyword{nil, []string{"at:"}, []expr{send(nil, "i")}, "parent", 0}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+	if s := Selector(parseExpr(t, "resend.at: 1 Put: 2")); s != "at:Put:" {
+		t.Errorf("expected selector at:Put: but found %q", s)
+	}
+}
+
+func TestParseLowercaseKeywords(t *testing.T) {
+	tests := []struct {
+		source   string
+		mode     Mode
+		expected expr
+		errs     []Error
+	}{
+		{"a at: 1 put: 2", 0, &keyword{send(nil, "a"), []string{"at:"}, []expr{&keyword{&numberLit{"1", 0}, []string{"put:"}, []expr{&numberLit{"2", 0}}, "", 0}}, "", 0}, nil},
+		{"a at: 1 put: 2", LowercaseKeywords, &keyword{send(nil, "a"), []string{"at:", "put:"}, []expr{&numberLit{"1", 0}, &numberLit{"2", 0}}, "", 0}, nil},
+		{"a at: 1 Put: 2", 0, &keyword{send(nil, "a"), []string{"at:", "Put:"}, []expr{&numberLit{"1", 0}, &numberLit{"2", 0}}, "", 0}, nil},
+		{"a at: 1 Put: 2", LowercaseKeywords, &keyword{send(nil, "a"), []string{"at:", "Put:"}, []expr{&numberLit{"1", 0}, &numberLit{"2", 0}}, "", 0}, nil},
+		{"a at: (b foo: 1) put: c + 2", LowercaseKeywords, &keyword{send(nil, "a"), []string{"at:", "put:"}, []expr{
+			&object{body: []expr{&keyword{send(nil, "b"), []string{"foo:"}, []expr{&numberLit{"1", 0}}, "", 0}}},
+			&binary{send(nil, "c"), "+", &numberLit{"2", 0}, "", 0},
+		}, "", 0}, nil},
+		{"a at: put: 2", LowercaseKeywords, &keyword{send(nil, "a"), []string{"at:", "put:"}, []expr{nil, &numberLit{"2", 0}}, "", 0}, []Error{{6, "missing argument for 'at:'"}}},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{Mode: test.mode})
+		e := clearPos(p.parseExpr())
+		p.close()
+		var errs []Error
+		for _, err := range p.errors {
+			errs = append(errs, *err.(*Error))
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q, mode %d: expected errors %v but found %v", test.source, test.mode, test.errs, errs)
+		}
+		if !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q, mode %d: expected %#v but found %#v", test.source, test.mode, test.expected, e)
+		}
+	}
+}
+
+func TestParseEmptyBodies(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"()", &object{}},
+		{"(||)", &object{}},
+		{"(| |)", &object{}},
+		{"[]", &block{}},
+		{"[||]", &block{}},
+		{"[:x | ]", &block{slots: []*slot{{name: "x", kind: argumentSlot}}}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseObjectSlots(t *testing.T) {
+	three, four := &numberLit{"3", 0}, &numberLit{"4", 0}
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"(| x = 3 |)", &object{slots: []*slot{{name: "x", kind: dataSlot, value: three}}}},
+		{"(| x = 3. y <- 4 |)", &object{slots: []*slot{{name: "x", kind: dataSlot, value: three}, {name: "y", kind: assignableSlot, value: four}}}},
+		{"(| x = 3. y <- 4. | x + y)", &object{
+			slots: []*slot{{name: "x", kind: dataSlot, value: three}, {name: "y", kind: assignableSlot, value: four}},
+			body:  []expr{&binary{send(nil, "x"), "+", send(nil, "y"), "", 0}},
+		}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseClosers(t *testing.T) {
+	ab := &binary{send(nil, "a"), "+", send(nil, "b"), "", 0}
+	afoo := send(send(nil, "a"), "foo")
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"[a + b]", &block{body: []expr{ab}}},
+		{"(a foo)", &object{body: []expr{afoo}}},
+		{"[a + b] value", send(&block{body: []expr{ab}}, "value")},
+		{"(a foo) + [a + b]", &binary{&object{body: []expr{afoo}}, "+", &block{body: []expr{ab}}, "", 0}},
+		{"[(a foo)]", &block{body: []expr{&object{body: []expr{afoo}}}}},
+		{"(a at: b)", &object{body: []expr{&keyword{send(nil, "a"), []string{"at:"}, []expr{send(nil, "b")}, "", 0}}}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseBlocks(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"[]", &block{}},
+		{"[ 1 + 2 ]", &block{body: []expr{&binary{&numberLit{"1", 0}, "+", &numberLit{"2", 0}, "", 0}}}},
+		{"[:x | x foo ]", &block{slots: []*slot{{name: "x", kind: argumentSlot}}, body: []expr{send(send(nil, "x"), "foo")}}},
+		{"[:x | | t | t: x. t]", &block{
+			slots: []*slot{{name: "x", kind: argumentSlot}, {name: "t", kind: assignableSlot}},
+			body:  []expr{&keyword{nil, []string{"t:"}, []expr{send(nil, "x")}, "", 0}, send(nil, "t")},
+		}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseBlockArguments(t *testing.T) {
+	arg := func(name string) *slot { return &slot{name: name, kind: argumentSlot} }
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"[:x | x]", &block{slots: []*slot{arg("x")}, body: []expr{send(nil, "x")}}},
+		{"[:x :y | x + y]", &block{slots: []*slot{arg("x"), arg("y")}, body: []expr{&binary{send(nil, "x"), "+", send(nil, "y"), "", 0}}}},
+		{"[:x:y | y]", &block{slots: []*slot{arg("x"), arg("y")}, body: []expr{send(nil, "y")}}},
+		{"[body]", &block{body: []expr{send(nil, "body")}}},
+		{"[:x | | t | t: x]", &block{slots: []*slot{arg("x"), {name: "t", kind: assignableSlot}},
+			body: []expr{&keyword{nil, []string{"t:"}, []expr{send(nil, "x")}, "", 0}}}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+
+	p := parse("test", "[:x x]", Config{})
+	p.parseExpr()
+	p.close()
+	if len(p.errors) == 0 {
+		t.Errorf("expected an error for arguments without a bar")
+	}
+}
+
+func TestParseSequence(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected *sequence
+	}{
+		{"a. b. c", &sequence{exprs: []expr{send(nil, "a"), send(nil, "b"), send(nil, "c")}}},
+		{"a. b.", &sequence{exprs: []expr{send(nil, "a"), send(nil, "b")}}},
+		{"", &sequence{}},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{})
+		s := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", test.source, err)
+		}
+		if clearPos(s); !reflect.DeepEqual(s, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, s)
+		}
+	}
+}
+
+func TestParseEmptyStatements(t *testing.T) {
+	tests := []struct {
+		source string
+		stmts  int
+		errs   []Error
+	}{
+		{"a.. b", 2, []Error{{2, "empty statement"}}},
+		{". a", 1, []Error{{0, "empty statement"}}},
+		{"a. . . b", 2, []Error{{3, "empty statement"}, {5, "empty statement"}}},
+		{"a. b", 2, nil},
+		{"a. b.", 2, nil},
+		{"(a.. b)", 1, []Error{{3, "empty statement"}}},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{})
+		s := p.parseProgram()
+		p.close()
+		if len(s.exprs) != test.stmts {
+			t.Errorf("%q: expected %d statements but found %d", test.source, test.stmts, len(s.exprs))
+		}
+		var errs []Error
+		for _, err := range p.errors {
+			errs = append(errs, *err.(*Error))
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q: expected errors %v but found %v", test.source, test.errs, errs)
+		}
+	}
+}
+
+func TestParseCommentOnlyProgram(t *testing.T) {
+	tests := []struct {
+		source string
+		mode   Mode
+		stmts  int
+		doc    string
+	}{
+		{`"just docs"`, 0, 0, ""},
+		{`"just docs"`, ParseComments, 0, "just docs"},
+		{"\"first\"\n\"second\"\n", ParseComments, 0, "first\nsecond"},
+		{`"about a" a. "about b" b`, ParseComments, 2, "about a"},
+		{`a "trailing"`, ParseComments, 1, ""},
+		{`""`, ParseComments, 0, ""},
+		{"# first\n\"second\"\na", HashComments | ParseComments, 1, " first\nsecond"},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{Mode: test.mode})
+		s := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", test.source, err)
+		}
+		if s == nil {
+			t.Errorf("%q: expected a program", test.source)
+			continue
+		}
+		if len(s.exprs) != test.stmts {
+			t.Errorf("%q: expected %d statements but found %d", test.source, test.stmts, len(s.exprs))
+		}
+		if s.doc != test.doc {
+			t.Errorf("%q: expected doc comment %q but found %q", test.source, test.doc, s.doc)
+		}
+	}
+}
+
+func TestParseTrailingComments(t *testing.T) {
+	tests := []struct {
+		source  string
+		mode    Mode
+		doc     string
+		trailer string
+	}{
+		{`a. "done"`, ParseComments, "", "done"},
+		{`a. "done"`, 0, "", ""},
+		{`"doc" a "about b" . b "one" "two"`, ParseComments, "doc", "one\ntwo"},
+		{`a "not trailing". b.`, ParseComments, "", ""},
+		{`"just docs"`, ParseComments, "just docs", ""},
+		{"a. # done", ParseComments | HashComments, "", " done"},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{Mode: test.mode})
+		s := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", test.source, err)
+		}
+		if s.doc != test.doc || s.trailer != test.trailer {
+			t.Errorf("%q: expected doc %q and trailer %q but found %q and %q", test.source, test.doc, test.trailer, s.doc, s.trailer)
+		}
+	}
+}
+
+func TestMaxKeywords(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("a at: b")
+	for i := 1; i < 50; i++ {
+		b.WriteString(" At: b")
+	}
+	source := b.String()
+
+	tests := []struct {
+		max  int
+		errs []error
+	}{
+		{0, []error{&Error{2, "keyword message has 50 parts, more than the limit of 32"}}},
+		{49, []error{&Error{2, "keyword message has 50 parts, more than the limit of 49"}}},
+		{50, nil},
+		{-1, nil},
+	}
+	for _, test := range tests {
+		p := parse("test", source, Config{MaxKeywords: test.max})
+		p.parseProgram()
+		p.close()
+		if !reflect.DeepEqual(p.errors, test.errs) {
+			t.Errorf("limit %d: expected %v but found %v", test.max, test.errs, p.errors)
+		}
+	}
+}
+
+func TestParseObjectArgument(t *testing.T) {
+	e := parseExpr(t, "a foo: (| x = 1 |) Bar: (| y <- 2. z |)")
+	first := &object{slots: []*slot{{name: "x", kind: dataSlot, value: &numberLit{"1", 0}}}}
+	second := &object{slots: []*slot{{name: "y", kind: assignableSlot, value: &numberLit{"2", 0}}, {name: "z", kind: assignableSlot}}}
+	expected := &keyword{send(nil, "a"), []string{"foo:", "Bar:"}, []expr{first, second}, "", 0}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %#v but found %#v", expected, e)
+	}
+}
+
+func TestParseParentSlots(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"(| parent* = x |)", &object{slots: []*slot{{name: "parent", kind: dataSlot, parent: true, value: send(nil, "x")}}}},
+		{"(| p* <- x. q* |)", &object{slots: []*slot{
+			{name: "p", kind: assignableSlot, parent: true, value: send(nil, "x")},
+			{name: "q", kind: assignableSlot, parent: true},
+		}}},
+		{"(| parent = x |)", &object{slots: []*slot{{name: "parent", kind: dataSlot, value: send(nil, "x")}}}},
+		{"(| * n = (n) |)", &object{slots: []*slot{{name: "*", kind: dataSlot, params: []string{"n"}, value: &object{body: []expr{send(nil, "n")}}}}}},
+		{"a * b", &binary{send(nil, "a"), "*", send(nil, "b"), "", 0}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseBinaryMethodSlots(t *testing.T) {
+	value := func(receiver expr) expr { return send(receiver, "value") }
+	plus := &binary{value(&selfExpr{}), "+", value(send(nil, "other")), "", 0}
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"(| + other = (self value + other value) |)", &object{slots: []*slot{
+			{name: "+", kind: dataSlot, params: []string{"other"}, value: &object{body: []expr{plus}}},
+		}}},
+		{"(| <= x = (x). x = 3 |)", &object{slots: []*slot{
+			{name: "<=", kind: dataSlot, params: []string{"x"}, value: &object{body: []expr{send(nil, "x")}}},
+			{name: "x", kind: dataSlot, value: &numberLit{"3", 0}},
+		}}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+
+	for _, source := range []string{"(| + = (x) |)", "(| + a b = (x) |)", "(| + a |)"} {
+		p := parse("test", source, Config{})
+		p.parseExpr()
+		p.close()
+		if len(p.errors) == 0 {
+			t.Errorf("%q: expected an error", source)
+		}
+	}
+}
+
+func TestParsePartial(t *testing.T) {
+	ab := send(send(nil, "a"), "b")
+	tests := []struct {
+		parse    func(name, input string) (expr, int, []error)
+		source   string
+		expected expr
+		end      int
+	}{
+		{ParseUnaryExpr, "a b", ab, 3},
+		{ParseUnaryExpr, "a b + c", ab, 4},
+		{ParseUnaryExpr, "a b foo: c", ab, 4},
+		{ParseUnaryExpr, "a b) c", ab, 3},
+		{ParseBinaryExpr, "a b + c", &binary{ab, "+", send(nil, "c"), "", 0}, 7},
+		{ParseBinaryExpr, "a b + c foo: d", &binary{ab, "+", send(nil, "c"), "", 0}, 8},
+		{ParseBinaryExpr, "a b foo: c", ab, 4},
+	}
+	for _, test := range tests {
+		e, end, errs := test.parse("test", test.source)
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors %v", test.source, errs)
+		}
+		if e = clearPos(e); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+		if end != test.end {
+			t.Errorf("%q: expected to end at %d but ended at %d", test.source, test.end, end)
+		}
+	}
+}
+
+func TestParseWithCallback(t *testing.T) {
+	tests := []struct {
+		source string
+		count  int
+		errors int
+	}{
+		{"", 0, 0},
+		{"a", 1, 0},
+		{"a. b foo. c + d.", 3, 0},
+		{"a. (| x = 3 | x. x). b", 3, 0},
+		{"a. . b", 2, 1},
+		{"a ) b", 1, 1},
+	}
+	for _, test := range tests {
+		count := 0
+		errs := ParseWithCallback("test", test.source, func(e expr) {
+			if e == nil {
+				t.Errorf("%q: callback with nil statement", test.source)
+			}
+			count++
+		})
+		if count != test.count {
+			t.Errorf("%q: expected %d statements but found %d", test.source, test.count, count)
+		}
+		if len(errs) != test.errors {
+			t.Errorf("%q: expected %d errors but found %v", test.source, test.errors, errs)
+		}
+	}
+}
+
+func TestParseReturn(t *testing.T) {
+	p := parse("test", "a. ^b foo", Config{})
+	s := p.parseProgram()
+	p.close()
+	for _, err := range p.errors {
+		t.Errorf("unexpected error: %v", err)
+	}
+	expected := &sequence{exprs: []expr{
+		&unary{nil, "a", "", 0},
+		&returnStmt{&unary{&unary{nil, "b", "", 4}, "foo", "", 6}, 3},
+	}}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %#v but found %#v", expected, s)
+	}
+
+	if e := parseExpr(t, "[:x | x foo. ^x]"); !reflect.DeepEqual(e, &block{
+		slots: []*slot{{name: "x", kind: argumentSlot}},
+		body:  []expr{send(send(nil, "x"), "foo"), &returnStmt{send(nil, "x"), 0}},
+	}) {
+		t.Errorf("unexpected block %#v", e)
+	}
+
+	for _, test := range []struct {
+		source   string
+		expected expr
+	}{
+		{"^foo: 1", &returnStmt{&keyword{nil, []string{"foo:"}, []expr{&numberLit{"1", 0}}, "", 0}, 0}},
+		{"^a foo: b", &returnStmt{&keyword{send(nil, "a"), []string{"foo:"}, []expr{send(nil, "b")}, "", 0}, 0}},
+		{"^a + b", &returnStmt{&binary{send(nil, "a"), "+", send(nil, "b"), "", 0}, 0}},
+		{"^a b c", &returnStmt{send(send(send(nil, "a"), "b"), "c"), 0}},
+		{"^ 42", &returnStmt{&numberLit{"42", 0}, 0}},
+		{"^ self foo", &returnStmt{send(&selfExpr{}, "foo"), 0}},
+	} {
+		p := parse("test", test.source, Config{})
+		s := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", test.source, err)
+		}
+		if len(s.exprs) != 1 {
+			t.Errorf("%q: expected one statement but found %d", test.source, len(s.exprs))
+			continue
+		}
+		if e := clearPos(s.exprs[0]); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+
+	for _, test := range []struct {
+		source string
+		errs   []Error
+	}{
+		{"a + ^b", []Error{{4, "return is only allowed at the start of a statement"}}},
+		{"a foo: ^b", []Error{{7, "return is only allowed at the start of a statement"}}},
+		{"^ ^a", []Error{{2, "return is only allowed at the start of a statement"}}},
+		{"^", []Error{{1, "missing expression after '^'"}}},
+		{"a. ^. b", []Error{{4, "missing expression after '^'"}}},
+		{"[:x | ^]", []Error{{7, "missing expression after '^'"}}},
+	} {
+		p := parse("test", test.source, Config{})
+		p.parseProgram()
+		p.close()
+		var errs []Error
+		for _, err := range p.errors {
+			errs = append(errs, *err.(*Error))
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q: expected errors %v but found %v", test.source, test.errs, errs)
+		}
+	}
+}
+
+func TestParseNoDirectedResends(t *testing.T) {
+	tests := []struct {
+		source string
+		mode   Mode
+		errs   []Error
+	}{
+		{"parent.foo. resend.bar", 0, nil},
+		{"parent.foo. resend.bar", NoDirectedResends, []Error{{0, "directed resend to 'parent' is not allowed; use 'resend'"}}},
+		{"x at: 1 Put: (p.+ 2)", NoDirectedResends, []Error{{14, "directed resend to 'p' is not allowed; use 'resend'"}}},
+		{"q.at: 1. resend.+ 2. resend.at: 3", NoDirectedResends, []Error{{0, "directed resend to 'q' is not allowed; use 'resend'"}}},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{Mode: test.mode})
+		p.parseProgram()
+		p.close()
+		var errs []Error
+		for _, err := range p.errors {
+			errs = append(errs, *err.(*Error))
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q (mode %d): expected errors %v but found %v", test.source, test.mode, test.errs, errs)
+		}
+	}
+}
+
+func TestParseMissingArgument(t *testing.T) {
+	tests := []struct {
+		source string
+		stmts  int
+		errs   []Error
+	}{
+		{"foo: . bar", 2, []Error{{5, "missing argument for 'foo:'"}}},
+		{"a foo: 1 Bar: . b", 2, []Error{{14, "missing argument for 'Bar:'"}}},
+		{"a foo: Bar: 2", 1, []Error{{7, "missing argument for 'foo:'"}}},
+		{"a foo:", 1, []Error{{6, "missing argument for 'foo:'"}}},
+		{"[a foo: ]. b", 2, []Error{{8, "missing argument for 'foo:'"}}},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{})
+		s := p.parseProgram()
+		p.close()
+		if len(s.exprs) != test.stmts {
+			t.Errorf("%q: expected %d statements but found %d", test.source, test.stmts, len(s.exprs))
+		}
+		var errs []Error
+		for _, err := range p.errors {
+			errs = append(errs, *err.(*Error))
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q: expected errors %v but found %v", test.source, test.errs, errs)
+		}
+	}
+	p := parse("test", "foo: . bar", Config{})
+	s := p.parseProgram()
+	p.close()
+	if e := clearPos(s.exprs[1]); !reflect.DeepEqual(e, send(nil, "bar")) {
+		t.Errorf("expected bar but found %#v", e)
+	}
+}
+
+func TestParseCollectsErrors(t *testing.T) {
+	n, errs := Parse("test", "a foo: . (| x = |). b + ")
+	expected := []error{
+		&Error{7, "missing argument for 'foo:'"},
+		&Error{16, "expected expression, found '|'"},
+		&Error{22, "missing right operand for '+'"},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v but found %v", expected, errs)
+	}
+	if s, ok := n.(*sequence); !ok || len(s.exprs) != 3 {
+		t.Errorf("expected three statements but found %#v", n)
+	}
+}
+
+func TestParseObjectsRecover(t *testing.T) {
+	n, errs := Parse("test", "(| x = (a foo: ) |).\n(| y = 3. z <- 4 |).")
+	expected := []error{&Error{15, "missing argument for 'foo:'"}}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v but found %v", expected, errs)
+	}
+	s, ok := n.(*sequence)
+	if !ok || len(s.exprs) != 2 {
+		t.Fatalf("expected two statements but found %#v", n)
+	}
+	for i, names := range [][]string{{"x"}, {"y", "z"}} {
+		if _, ok := s.exprs[i].(*object); !ok {
+			t.Errorf("statement %d: expected an object but found %#v", i, s.exprs[i])
+		} else if found := SlotNames(s.exprs[i]); !reflect.DeepEqual(found, names) {
+			t.Errorf("statement %d: expected slots %v but found %v", i, names, found)
+		}
+	}
+}
+
+func TestParseCloseStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		// Each parser stops well before the end of its input.
+		p := parse("test", "a. b. c. d. e", Config{})
+		if e := p.parseStatement(); e == nil {
+			t.Error("expected a statement")
+		}
+		p.close()
+		ParseObject("test", "a foo. (| x |). (| y |)")
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d goroutines but found %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParseObject(t *testing.T) {
+	n, errs := ParseObject("test", "\"point\"\n(| x <- 0. y <- 0. + p = (p) |).\n")
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if o, ok := n.(*object); !ok || len(o.slots) != 3 {
+		t.Errorf("expected an object with three slots but found %#v", n)
+	}
+
+	tests := []struct {
+		source string
+		object bool
+		errs   []error
+	}{
+		{"", false, []error{&Error{0, "expected object literal"}}},
+		{"a foo", false, []error{&Error{0, "expected object literal"}}},
+		{"(| x |). a foo", true, []error{&Error{9, "expected a single object literal"}}},
+		{"[| x | x]", false, []error{&Error{0, "expected object literal"}}},
+		{"(| x |). (| y |)", true, []error{&Error{9, "expected a single object literal"}}},
+	}
+	for _, test := range tests {
+		n, errs := ParseObject("test", test.source)
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q: expected errors %v but found %v", test.source, test.errs, errs)
+		}
+		if _, ok := n.(*object); ok != test.object {
+			t.Errorf("%q: unexpected result %#v", test.source, n)
+		}
+	}
+}
+
+func TestParseUnary(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"3 printString", send(&numberLit{"3", 0}, "printString")},
+		{"foo bar baz", send(send(send(nil, "foo"), "bar"), "baz")},
+		{"(a b) c", send(&object{body: []expr{send(send(nil, "a"), "b")}}, "c")},
+		{"self foo", send(&selfExpr{}, "foo")},
+		{"resend.foo bar", send(&unary{nil, "foo", "resend", 0}, "bar")},
+		{"p.foo", &unary{nil, "foo", "p", 0}},
+		{"[a] value", send(&block{body: []expr{send(nil, "a")}}, "value")},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
+
+func TestParseStart(t *testing.T) {
+	start := Position{Offset: 200, Line: 10, Column: 5}
+	c := Config{Start: start}
+	expected := []item{
+		{TokenIdentifier, "a", 200, 10, 5},
+		{TokenSmallKeyword, "foo:", 202, 10, 7},
+		{TokenIdentifier, "b", 208, 11, 2},
+		{TokenEOF, "", 209, 11, 3},
+	}
+	if items := collect(lex("test", "a foo:\n b", c)); !reflect.DeepEqual(items, expected) {
+		t.Errorf("expected %v but found %v", expected, items)
+	}
+
+	p := parse("test", "a foo:\n . b +", c)
+	p.parseProgram()
+	p.close()
+	errs := []error{&Error{208, "missing argument for 'foo:'"}, &Error{212, "missing right operand for '+'"}}
+	if !reflect.DeepEqual(p.errors, errs) {
+		t.Errorf("expected %v but found %v", errs, p.errors)
+	}
+	if p.line != 11 || p.col != 7 {
+		t.Errorf("expected to end at 11:7 but ended at %d:%d", p.line, p.col)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	source := "\"doc\"\nx: 'a\tb'. _ foo. a at: 1 Put: 2\n\"end\""
+	n, errs, warnings := ParseConfig("test", source, Config{Mode: ParseComments | CheckStringTabs | Placeholders})
+	if errs != nil {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if w := []Warning{{11, "raw tab in string literal; use '\\t'"}}; !reflect.DeepEqual(warnings, w) {
+		t.Errorf("expected warnings %v but found %v", w, warnings)
+	}
+	if s := Format(n); s != "\"doc\"\nx: 'a\\tb'. _ foo. a at: 1 Put: 2\n\"end\"" {
+		t.Errorf("unexpected tree %q", s)
+	}
+
+	_, errs, _ = ParseConfig("test", "a b: 1 C: 2", Config{MaxKeywords: 1, Start: Position{Offset: 100, Line: 5, Column: 1}})
+	if e := []error{&Error{102, "keyword message has 2 parts, more than the limit of 1"}}; !reflect.DeepEqual(errs, e) {
+		t.Errorf("expected errors %v but found %v", e, errs)
+	}
+
+	var aborted []int
+	n, errs, _ = ParseConfig("test", "a foo: . b +", Config{Error: func(pos int, msg string) {
+		aborted = append(aborted, pos)
+		panic(ErrAbort)
+	}})
+	if n != nil || errs != nil || !reflect.DeepEqual(aborted, []int{7}) {
+		t.Errorf("expected to abort at 7 with a nil tree but found %#v, %v and %v", n, errs, aborted)
+	}
+}
+
+func TestParseConfigAbortFirstItem(t *testing.T) {
+	abort := func(int, string) { panic(ErrAbort) }
+	tests := []struct {
+		source string
+		c      Config
+	}{
+		{"'abc", Config{Error: abort}},
+		{"\x01 a", Config{Error: abort}},
+		{"'a\tb' c", Config{Mode: CheckStringTabs, Warn: abort}},
+	}
+	before := runtime.NumGoroutine()
+	for _, test := range tests {
+		n, errs, warnings := ParseConfig("test", test.source, test.c)
+		if n != nil || errs != nil || warnings != nil {
+			t.Errorf("%q: expected to abort with nothing but found %#v, %v and %v", test.source, n, errs, warnings)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d goroutines but found %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParseSelf(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected expr
+	}{
+		{"self", &selfExpr{}},
+		{"self foo", send(&selfExpr{}, "foo")},
+		{"foo", send(nil, "foo")},
+		{"self + 1", &binary{&selfExpr{}, "+", &numberLit{"1", 0}, "", 0}},
+		{"+ 1", &binary{nil, "+", &numberLit{"1", 0}, "", 0}},
+		{"self at: 1", &keyword{&selfExpr{}, []string{"at:"}, []expr{&numberLit{"1", 0}}, "", 0}},
+		{"at: 1", &keyword{nil, []string{"at:"}, []expr{&numberLit{"1", 0}}, "", 0}},
+	}
+	for _, test := range tests {
+		if e := parseExpr(t, test.source); !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%q: expected %#v but found %#v", test.source, test.expected, e)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package ego
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Format returns canonical source text for the tree rooted at n. Parsing the
+// text gives back the same tree, apart from positions. Sends that could only
+// have been built by hand, such as a keyword send as the receiver of another,
+// are parenthesized, which makes them objects when parsed.
+func Format(n Node) string {
+	var p printer
+	p.expr(n, precKeyword)
+	return p.String()
+}
+
+// MinSource is like Format, but omits spaces that are not needed to separate
+// tokens, giving the shortest source for the tree. Like Format, it adds
+// parentheses only where precedence requires them.
+func MinSource(n Node) string {
+	p := printer{compact: true}
+	p.expr(n, precKeyword)
+	return p.String()
+}
+
+// Precedences, from loosest to tightest binding. An expression printed where
+// a tighter one is needed is parenthesized.
+const (
+	precKeyword = iota
+	precBinary
+	precUnary
+)
+
+// A printer accumulates the text of a tree.
+type printer struct {
+	strings.Builder
+	compact bool // omit spaces not needed to separate tokens
+	space   bool // whether a space is pending
+	join    bool // whether a space is pending only if needed, even if not compact
+}
+
+// write writes s, preceded by any pending space. In compact mode, or after
+// join, a pending space is written only if s would otherwise run into the
+// text before it.
+func (p *printer) write(s string) {
+	if (p.space || p.join) && s != "" {
+		b := p.String()
+		if p.space && !p.compact || len(b) > 0 && runsInto(b[len(b)-1], s[0]) {
+			p.WriteByte(' ')
+		}
+		p.space, p.join = false, false
+	}
+	p.WriteString(s)
+}
+
+// sep separates what was written from what follows with a space.
+func (p *printer) sep() { p.space = true }
+
+// adjoin separates what was written from what follows with a space only if
+// they would otherwise lex differently.
+func (p *printer) adjoin() { p.join = true }
+
+// runsInto reports whether text ending in a and text starting with b might
+// lex differently without a space between them. Periods separating
+// statements are always followed by a space, so they cannot end delegates,
+// and so is a '-' followed by a digit, which would start a negative number.
+func runsInto(a, b byte) bool {
+	isWord := func(c byte) bool {
+		return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+	}
+	isOp := func(c byte) bool { return strings.IndexByte(operatorChars, c) >= 0 }
+	return a == '.' || isWord(a) && (isWord(b) || b == ':') || isOp(a) && isOp(b) || a == '-' && '0' <= b && b <= '9'
+}
+
+// precedence returns the precedence of e.
+func precedence(e expr) int {
+	switch e.(type) {
+	case *keyword, *returnStmt:
+		return precKeyword
+	case *binary:
+		return precBinary
+	}
+	return precUnary
+}
+
+// expr prints e, parenthesized if it binds more loosely than prec.
+func (p *printer) expr(e expr, prec int) {
+	if e != nil && precedence(e) < prec {
+		p.write("(")
+		defer p.write(")")
+	}
+	switch e := e.(type) {
+	case *unary:
+		p.receiver(e.receiver, e.delegate, precUnary)
+		p.write(e.selector)
+	case *binary:
+		prec := precBinary
+		if r, ok := e.receiver.(*binary); ok && r.operator != e.operator {
+			prec = precUnary // operators cannot be mixed without parentheses
+		}
+		p.receiver(e.receiver, e.delegate, prec)
+		p.write(e.operator)
+		p.sep()
+		if k, ok := e.argument.(*keyword); ok && k.receiver == implicitSelf && k.delegate == "" {
+			p.expr(k, precKeyword)
+		} else {
+			p.expr(e.argument, precUnary)
+		}
+	case *keyword:
+		p.receiver(e.receiver, e.delegate, precBinary)
+		for i, kw := range e.keywords {
+			if i > 0 {
+				p.sep()
+			}
+			p.write(kw)
+			p.sep()
+			if i < len(e.arguments)-1 {
+				// A keyword send here would take the later keywords.
+				p.expr(e.arguments[i], precBinary)
+			} else if i < len(e.arguments) {
+				p.expr(e.arguments[i], precKeyword)
+			}
+		}
+	case *selfExpr:
+		p.write("self")
+	case *numberLit:
+		p.write(e.text)
+	case *stringLit:
+		p.write(quote(e.value))
+	case *placeholder:
+		p.write("_")
+	case *returnStmt:
+		p.write("^")
+		p.adjoin()
+		p.expr(e.value, precKeyword)
+	case *sequence:
+		if e.doc != "" {
+			for _, line := range strings.Split(e.doc, "\n") {
+				p.write(comment(line) + "\n")
+			}
+		}
+		p.statements(e.exprs)
+		if e.trailer != "" {
+			for _, line := range strings.Split(e.trailer, "\n") {
+				p.write("\n" + comment(line))
+			}
+		}
+	case *object:
+		p.write("(")
+		p.slots(e.slots, len(e.body) > 0)
+		p.statements(e.body)
+		p.write(")")
+	case *block:
+		p.write("[")
+		slots := e.slots
+		for len(slots) > 0 && slots[0].kind == argumentSlot {
+			p.write(":" + slots[0].name)
+			p.sep()
+			slots = slots[1:]
+		}
+		if len(slots) < len(e.slots) {
+			p.write("|")
+			p.sep()
+		}
+		p.slots(slots, len(e.body) > 0)
+		p.statements(e.body)
+		p.write("]")
+	}
+}
+
+// receiver prints the receiver of a send, which is implicit if it has a
+// delegate, followed by a space or the delegate's period.
+func (p *printer) receiver(e expr, delegate string, prec int) {
+	switch {
+	case delegate != "":
+		p.write(delegate + ".")
+	case e != implicitSelf:
+		p.expr(e, prec)
+		p.sep()
+	}
+}
+
+// statements prints a list of statements separated by periods.
+func (p *printer) statements(list []expr) {
+	for i, e := range list {
+		if i > 0 {
+			p.write(".")
+			p.sep()
+		}
+		p.expr(e, precKeyword)
+	}
+}
+
+// slots prints a non-empty slot list between bars, followed by a space if
+// statements follow.
+func (p *printer) slots(slots []*slot, statements bool) {
+	if len(slots) == 0 {
+		return
+	}
+	if statements {
+		defer p.sep()
+	}
+	p.write("|")
+	p.sep()
+	for i, s := range slots {
+		if i > 0 {
+			p.write(".")
+			p.sep()
+		}
+		p.slot(s)
+	}
+	p.sep()
+	p.write("|")
+}
+
+func (p *printer) slot(s *slot) {
+	switch {
+	case s.kind == argumentSlot:
+		p.write(":" + s.name)
+		return
+	case len(s.params) > 0 && !strings.HasSuffix(s.name, ":"):
+		p.write(s.name)
+		p.sep()
+		p.write(s.params[0])
+	case len(s.params) > 0:
+		for i, kw := range strings.SplitAfter(s.name, ":")[:len(s.params)] {
+			if i > 0 {
+				p.sep()
+			}
+			p.write(kw)
+			p.sep()
+			p.write(s.params[i])
+		}
+	default:
+		p.write(s.name)
+	}
+	if s.parent {
+		p.write("*")
+	}
+	switch {
+	case s.kind == dataSlot:
+		p.sep()
+		p.write("=")
+	case s.value != nil:
+		p.sep()
+		p.write("<-")
+	default:
+		return
+	}
+	p.sep()
+	p.expr(s.value, precKeyword)
+}
+
+// comment returns a comment with the given text, which must be followed by
+// a line break or the end of the input. Comments have no escapes, so text
+// containing '"' can only have come from a '#' comment, in HashComments
+// mode, and is printed as one.
+func comment(text string) string {
+	if strings.Contains(text, `"`) {
+		return "#" + text
+	}
+	return `"` + text + `"`
+}
+
+// unescapes maps characters to the escapes that quote prints for them.
+var unescapes = map[rune]string{
+	'\t': `\t`, '\b': `\b`, '\n': `\n`, '\f': `\f`, '\r': `\r`, '\v': `\v`, '\a': `\a`, 0: `\0`,
+	'\\': `\\`, '\'': `\'`,
+}
+
+// quote returns a string literal denoting s. Unprintable characters and
+// bytes that are not valid UTF-8 are escaped.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		switch esc, ok := unescapes[r]; {
+		case ok:
+			b.WriteString(esc)
+		case r == utf8.RuneError && n == 1 || !unicode.IsPrint(r):
+			for _, c := range []byte(s[i : i+n]) {
+				b.WriteString(`\x`)
+				if c < 16 {
+					b.WriteByte('0')
+				}
+				b.WriteString(strconv.FormatUint(uint64(c), 16))
+			}
+		default:
+			b.WriteString(s[i : i+n])
+		}
+		i += n
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
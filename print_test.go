@@ -0,0 +1,155 @@
+package ego
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		source, text string
+	}{
+		{"a   foo", "a foo"},
+		{"a foo+b bar", "a foo + b bar"},
+		{"a at:1 Put:2", "a at: 1 Put: 2"},
+		{"a foo: b bar: c", "a foo: b bar: c"},
+		{"a + b + c", "a + b + c"},
+		{"a + (b + c)", "a + (b + c)"},
+		{"a + foo: b", "a + foo: b"},
+		{"resend.at: 1 Put: 2. p.foo. resend.- 3", "resend.at: 1 Put: 2. p.foo. resend.- 3"},
+		{"self foo. ^ self", "self foo. ^self"},
+		{"^ -1", "^ -1"},
+		{"-16rFF abs - -2.5e3", "-16rFF abs - -2.5e3"},
+		{`'it\'s'`, `'it\'s'`},
+		{"'tab\tnl\\n \\x00\\xff é'", `'tab\tnl\n \0\xff é'`},
+		{"(||)", "()"},
+		{"(|x. y <- 3. z = 4. p* = q. :a. + o = (o). at: i Put: v = (v)| x)", "(| x. y <- 3. z = 4. p* = q. :a. + o = (o). at: i Put: v = (v) | x)"},
+		{"(| x |)", "(| x |)"},
+		{"[]. [:x|]. [:x :y | | t | t]. [| :x. t | x]. [| t | t]", "[]. [:x | ]. [:x :y | | t | t]. [:x | | t | x]. [| t | t]"},
+		{"\"doc\"\n\"more\" a", "\"doc\"\n\"more\"\na"},
+		{"a. \"done\" \"really\"", "a\n\"done\"\n\"really\""},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{Mode: ParseComments})
+		e := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", test.source, err)
+		}
+		text := Format(e)
+		if text != test.text {
+			t.Errorf("%q: expected %q but found %q", test.source, test.text, text)
+		}
+		p = parse("test", text, Config{Mode: ParseComments})
+		reparsed := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", text, err)
+		}
+		if !reflect.DeepEqual(clearPos(reparsed), clearPos(e)) {
+			t.Errorf("%q: expected %#v but found %#v", text, e, reparsed)
+		}
+	}
+}
+
+func TestFormatBuilt(t *testing.T) {
+	inner := &keyword{send(nil, "a"), []string{"foo:"}, []expr{send(nil, "b")}, "", 0}
+	tests := []struct {
+		e    expr
+		text string
+	}{
+		{&keyword{inner, []string{"bar:"}, []expr{send(nil, "c")}, "", 0}, "(a foo: b) bar: c"},
+		{&keyword{send(nil, "x"), []string{"at:", "Put:"}, []expr{inner, inner}, "", 0}, "x at: (a foo: b) Put: a foo: b"},
+		{send(&binary{send(nil, "a"), "+", send(nil, "b"), "", 0}, "c"), "(a + b) c"},
+		{&binary{&binary{send(nil, "a"), "+", send(nil, "b"), "", 0}, "*", send(nil, "c"), "", 0}, "(a + b) * c"},
+		{&binary{send(nil, "a"), "+", inner, "", 0}, "a + (a foo: b)"},
+	}
+	for _, test := range tests {
+		if text := Format(test.e); text != test.text {
+			t.Errorf("expected %q but found %q", test.text, text)
+		}
+	}
+}
+
+func TestFormatHashComments(t *testing.T) {
+	c := Config{Mode: HashComments | ParseComments}
+	for _, source := range []string{
+		"#at: \"c\"\n\"plain\"\na foo. b\n# \"done\"",
+		"# no quotes\na\n#end \"x\"",
+	} {
+		n, errs, _ := ParseConfig("test", source, c)
+		if errs != nil {
+			t.Fatalf("%q: unexpected errors %v", source, errs)
+		}
+		for _, text := range []string{Format(n), MinSource(n)} {
+			reparsed, errs, _ := ParseConfig("test", text, c)
+			if errs != nil {
+				t.Errorf("%q: unexpected errors %v", text, errs)
+			}
+			if !reflect.DeepEqual(clearPos(reparsed), clearPos(n)) {
+				t.Errorf("%q: expected %#v but found %#v", text, n, reparsed)
+			}
+		}
+	}
+	n, _, _ := ParseConfig("test", "#at: \"c\"\na", c)
+	if text := Format(n); text != "#at: \"c\"\na" {
+		t.Errorf("expected a '#' comment but found %q", text)
+	}
+}
+
+func TestMinSource(t *testing.T) {
+	tests := []struct {
+		source, text string
+	}{
+		{"a foo bar", "a foo bar"},
+		{"a foo + b bar", "a foo+b bar"},
+		{"a - -1. a - 1. 3 - 1", "a- -1. a- 1. 3- 1"},
+		{"a at: 1 Put: 'x'", "a at:1 Put:'x'"},
+		{"(a foo: b) bar: c", "(a foo:b)bar:c"},
+		{"(a + (b + c)) * 2", "(a+(b+c))*2"},
+		{"16rFF foo. 2.5e3 foo", "16rFF foo. 2.5e3 foo"},
+		{"resend.at: 1. p.foo. resend.- 3", "resend.at:1. p.foo. resend.- 3"},
+		{"^ -1. ^ a", "^ -1. ^a"},
+		{"(| x. p* = q. y <- -3. + o = (o). at: i Put: v = (v) | x)", "(|x. p* =q. y<- -3. +o=(o). at:i Put:v=(v)|x)"},
+		{"[:x :y | | t | t]. [:x | x]. [| t | t]. []", "[:x :y| |t|t]. [:x|x]. [|t|t]. []"},
+	}
+	for _, test := range tests {
+		p := parse("test", test.source, Config{})
+		e := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", test.source, err)
+		}
+		text := MinSource(e)
+		if text != test.text {
+			t.Errorf("%q: expected %q but found %q", test.source, test.text, text)
+		}
+		p = parse("test", text, Config{})
+		reparsed := p.parseProgram()
+		p.close()
+		for _, err := range p.errors {
+			t.Errorf("%q: unexpected error: %v", text, err)
+		}
+		if !reflect.DeepEqual(clearPos(reparsed), clearPos(e)) {
+			t.Errorf("%q: expected %#v but found %#v", text, e, reparsed)
+		}
+	}
+
+	// Trees built by hand are parenthesized only where precedence requires.
+	ab := &binary{send(nil, "a"), "+", send(nil, "b"), "", 0}
+	built := []struct {
+		e    expr
+		text string
+	}{
+		{send(ab, "c"), "(a+b)c"},
+		{&binary{ab, "+", send(nil, "c"), "", 0}, "a+b+c"},
+		{&binary{ab, "*", send(nil, "c"), "", 0}, "(a+b)*c"},
+		{&binary{send(nil, "c"), "*", ab, "", 0}, "c*(a+b)"},
+		{&keyword{ab, []string{"at:"}, []expr{ab}, "", 0}, "a+b at:a+b"},
+	}
+	for _, test := range built {
+		if text := MinSource(test.e); text != test.text {
+			t.Errorf("expected %q but found %q", test.text, text)
+		}
+	}
+}
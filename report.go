@@ -0,0 +1,81 @@
+package ego
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Position is a location in the input.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number in characters, starting at 1
+}
+
+func (p Position) String() string { return fmt.Sprintf("%d:%d", p.Line, p.Column) }
+
+// after returns the Position following s, which starts at p, expanding tabs
+// to tabWidth columns if it is positive.
+func (p Position) after(s string, tabWidth int) Position {
+	p.Offset += len(s)
+	for _, r := range s {
+		p.Line, p.Column = advance(p.Line, p.Column, r, tabWidth)
+	}
+	return p
+}
+
+// start returns the position of the start of the input configured by c.
+func (c Config) start() Position {
+	if c.Start.Line == 0 {
+		return Position{Line: 1, Column: 1}
+	}
+	return c.Start
+}
+
+// WriteErrors writes a report of errs, found in the input named name, to w.
+// Each syntax error is reported with its position and message, followed by
+// the line containing it and a marker under the offending column.
+func WriteErrors(w io.Writer, name, input string, errs []error) error {
+	return WriteErrorsConfig(w, name, input, Config{}, errs)
+}
+
+// WriteErrorsConfig is like WriteErrors, but for errors found in input
+// parsed as configured by c. Positions are reported in the host file given
+// by c.Start, with tabs expanded to c.TabWidth columns. An error positioned
+// outside the input is reported without its line.
+func WriteErrorsConfig(w io.Writer, name, input string, c Config, errs []error) error {
+	base := c.start()
+	for _, err := range errs {
+		e, ok := err.(*Error)
+		if ok && (e.Pos < base.Offset || e.Pos > base.Offset+len(input)) {
+			ok = false
+		}
+		if !ok {
+			if _, err := fmt.Fprintf(w, "%s: %v\n", name, err); err != nil {
+				return err
+			}
+			continue
+		}
+		pos := e.Pos - base.Offset
+		start := strings.LastIndexByte(input[:pos], '\n') + 1
+		end := strings.IndexByte(input[pos:], '\n')
+		if end < 0 {
+			end = len(input)
+		} else {
+			end += pos
+		}
+		line := input[start:end]
+		// Copy tabs into the marker so that it lines up with the source.
+		marker := strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			return ' '
+		}, input[start:pos])
+		if _, err := fmt.Fprintf(w, "%s:%s: %s\n\t%s\n\t%s^\n", name, base.after(input[:pos], c.TabWidth), e.Msg, line, marker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package ego
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestWriteErrors(t *testing.T) {
+	const source = "\"report\"\n(| x = a + .\n\ty <- (b foo: ) |)"
+	p := parse("test", source, Config{})
+	p.parseProgram()
+	p.close()
+	errs := append(p.errors, errors.New("not a syntax error"))
+	if len(errs) != 3 {
+		t.Fatalf("expected 2 syntax errors but found %v", p.errors)
+	}
+
+	var b bytes.Buffer
+	if err := WriteErrors(&b, "test.ego", source, errs); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "report.golden")
+	if *update {
+		if err := os.WriteFile(golden, b.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), expected) {
+		t.Errorf("expected\n%s\nbut found\n%s", expected, b.Bytes())
+	}
+}
+
+func TestPositionAfter(t *testing.T) {
+	const source = "ab\ncé\tf"
+	tests := []struct {
+		offset       int
+		line, column int
+	}{
+		{0, 1, 1},
+		{2, 1, 3},
+		{3, 2, 1},
+		{6, 2, 3},
+		{8, 2, 5},
+	}
+	for _, test := range tests {
+		expected := Position{test.offset, test.line, test.column}
+		if pos := (Position{Line: 1, Column: 1}).after(source[:test.offset], 0); pos != expected {
+			t.Errorf("%d: expected %v but found %v", test.offset, expected, pos)
+		}
+	}
+}
+
+func TestWriteErrorsStart(t *testing.T) {
+	const source = "a foo: .\n\tb +"
+	c := Config{Start: Position{Offset: 100, Line: 5, Column: 3}, TabWidth: 4}
+	_, errs, _ := ParseConfig("test", source, c)
+	errs = append(errs, &Error{3, "outside the input"})
+	var b bytes.Buffer
+	if err := WriteErrorsConfig(&b, "host.html", source, c, errs); err != nil {
+		t.Fatal(err)
+	}
+	expected := "host.html:5:10: missing argument for 'foo:'\n\ta foo: .\n\t       ^\n" +
+		"host.html:6:7: missing right operand for '+'\n\t\tb +\n\t\t  ^\n" +
+		"host.html: 3: outside the input\n"
+	if b.String() != expected {
+		t.Errorf("expected\n%s\nbut found\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,68 @@
+package ego
+
+import "strings"
+
+// SemanticTokenTypes is the legend for the token types reported by
+// SemanticTokens, which refers to them by index.
+var SemanticTokenTypes = []string{"variable", "method", "parameter", "operator", "number", "string", "keyword", "namespace"}
+
+var semanticTypes = [...]uint32{
+	TokenIdentifier:   0,
+	TokenPlaceholder:  0,
+	TokenSmallKeyword: 1,
+	TokenCapKeyword:   1,
+	TokenArgumentName: 2,
+	TokenOperator:     3,
+	TokenBar:          3,
+	TokenCaret:        3,
+	TokenLeftArrow:    3,
+	TokenEqual:        3,
+	TokenStar:         3,
+	TokenNumber:       4,
+	TokenString:       5,
+	TokenSelf:         6,
+	TokenResend:       6,
+	TokenDelegate:     7,
+	TokenPeriod:       noSemanticType,
+	TokenLeftParen:    noSemanticType,
+	TokenLeftBracket:  noSemanticType,
+	TokenLeftBrace:    noSemanticType,
+	TokenRightParen:   noSemanticType,
+	TokenRightBracket: noSemanticType,
+	TokenRightBrace:   noSemanticType,
+}
+
+const noSemanticType = ^uint32(0)
+
+// SemanticTokens lexes input and returns its tokens in the relative encoding
+// of the Language Server Protocol: five integers per token, giving its line
+// relative to the previous token, its start character (relative to the
+// previous token's if they are on the same line), its length, its index in
+// SemanticTokenTypes, and no modifiers. Characters are counted in UTF-16 code
+// units. Punctuation is omitted and lexing stops at the first error.
+func SemanticTokens(name, input string) (data []uint32) {
+	line, char := 1, 0
+	for i := range lex(name, input, Config{}) {
+		if i.t == TokenEOF || i.t == TokenError || semanticTypes[i.t] == noSemanticType {
+			continue
+		}
+		start := strings.LastIndexByte(input[:i.pos], '\n') + 1
+		c := utf16Len(input[start:i.pos])
+		if i.line != line {
+			char = 0
+		}
+		data = append(data, uint32(i.line-line), uint32(c-char), uint32(utf16Len(i.raw(input, 0))), semanticTypes[i.t], 0)
+		line, char = i.line, c
+	}
+	return
+}
+
+// utf16Len returns the number of UTF-16 code units encoding s.
+func utf16Len(s string) (n int) {
+	for _, r := range s {
+		if n++; r >= 0x10000 {
+			n++ // surrogate pair
+		}
+	}
+	return
+}
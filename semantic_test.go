@@ -0,0 +1,25 @@
+package ego
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSemanticTokens(t *testing.T) {
+	source := "(| x = 'é'. |)\n  x at: '𝔸' Put: 3"
+	expected := []uint32{
+		0, 1, 1, 3, 0, // |
+		0, 2, 1, 0, 0, // x
+		0, 2, 1, 3, 0, // =
+		0, 2, 3, 5, 0, // 'é'
+		0, 5, 1, 3, 0, // |
+		1, 2, 1, 0, 0, // x
+		0, 2, 3, 1, 0, // at:
+		0, 4, 4, 5, 0, // '𝔸'
+		0, 5, 4, 1, 0, // Put:
+		0, 5, 1, 4, 0, // 3
+	}
+	if data := SemanticTokens("test", source); !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %v but found %v", expected, data)
+	}
+}
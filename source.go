@@ -0,0 +1,34 @@
+package ego
+
+import (
+	"errors"
+	"unicode/utf16"
+)
+
+// DecodeSource returns src as UTF-8 text for lexing. Sources beginning with
+// a UTF-16 byte order mark, little- or big-endian, are transcoded, and a
+// UTF-8 byte order mark is dropped; other sources are returned unchanged.
+// Positions reported for the result are byte offsets in the UTF-8 text, not
+// in src.
+func DecodeSource(src []byte) (string, error) {
+	var order func(hi, lo byte) uint16
+	switch {
+	case len(src) >= 3 && src[0] == 0xEF && src[1] == 0xBB && src[2] == 0xBF:
+		return string(src[3:]), nil
+	case len(src) >= 2 && src[0] == 0xFF && src[1] == 0xFE:
+		order = func(a, b byte) uint16 { return uint16(b)<<8 | uint16(a) }
+	case len(src) >= 2 && src[0] == 0xFE && src[1] == 0xFF:
+		order = func(a, b byte) uint16 { return uint16(a)<<8 | uint16(b) }
+	default:
+		return string(src), nil
+	}
+	src = src[2:]
+	if len(src)%2 != 0 {
+		return "", errors.New("UTF-16 source has an odd number of bytes")
+	}
+	units := make([]uint16, len(src)/2)
+	for i := range units {
+		units[i] = order(src[2*i], src[2*i+1])
+	}
+	return string(utf16.Decode(units)), nil
+}
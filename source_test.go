@@ -0,0 +1,68 @@
+package ego
+
+import (
+	"testing"
+	"unicode/utf16"
+)
+
+// encodeUTF16 returns s encoded as UTF-16 with a byte order mark.
+func encodeUTF16(s string, bigEndian bool) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune("\uFEFF" + s)) {
+		if bigEndian {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	return b
+}
+
+func TestDecodeSource(t *testing.T) {
+	const source = "x <- 'é' foo: 𝛑"
+	tests := []struct {
+		src []byte
+		ok  bool
+	}{
+		{[]byte(source), true},
+		{append([]byte{0xEF, 0xBB, 0xBF}, source...), true},
+		{encodeUTF16(source, false), true},
+		{encodeUTF16(source, true), true},
+		{append(encodeUTF16(source, false), 'x'), false},
+	}
+	for i, test := range tests {
+		s, err := DecodeSource(test.src)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("[%d] expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %v", i, err)
+		}
+		if s != source {
+			t.Errorf("[%d] expected %q but found %q", i, source, s)
+		}
+	}
+}
+
+func TestLexUTF16(t *testing.T) {
+	s, err := DecodeSource(encodeUTF16("foo\n\tbar: 'é' baz", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []item{
+		{TokenIdentifier, "foo", 0, 1, 1},
+		{TokenSmallKeyword, "bar:", 5, 2, 2},
+		{TokenString, "é", 10, 2, 7},
+		{TokenIdentifier, "baz", 15, 2, 11},
+		{TokenEOF, "", 18, 2, 14},
+	}
+	items := lex("test", s, Config{})
+	for _, e := range expected {
+		if i := <-items; i != e {
+			t.Errorf("expected %s at %d but found %s at %d", e, e.pos, i, i.pos)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package ego
+
+// Walk traverses the tree rooted at e in depth-first order, calling fn for
+// each node before its children. If fn returns false, the children of that
+// node are skipped. Implicit receivers are not visited.
+func Walk(e expr, fn func(expr) bool) {
+	if e == nil || !fn(e) {
+		return
+	}
+	switch e := e.(type) {
+	case *unary:
+		Walk(e.receiver, fn)
+	case *binary:
+		Walk(e.receiver, fn)
+		Walk(e.argument, fn)
+	case *keyword:
+		Walk(e.receiver, fn)
+		for _, arg := range e.arguments {
+			Walk(arg, fn)
+		}
+	case *returnStmt:
+		Walk(e.value, fn)
+	case *sequence:
+		for _, s := range e.exprs {
+			Walk(s, fn)
+		}
+	case *object:
+		for _, s := range e.slots {
+			Walk(s.value, fn)
+		}
+		for _, s := range e.body {
+			Walk(s, fn)
+		}
+	case *block:
+		for _, s := range e.slots {
+			Walk(s.value, fn)
+		}
+		for _, s := range e.body {
+			Walk(s, fn)
+		}
+	}
+}
+
+// mapPos replaces each position recorded in the tree rooted at e with the
+// result of calling f on it.
+func mapPos(e expr, f func(int) int) {
+	Walk(e, func(e expr) bool {
+		switch e := e.(type) {
+		case *unary:
+			e.pos = f(e.pos)
+		case *binary:
+			e.pos = f(e.pos)
+		case *keyword:
+			e.pos = f(e.pos)
+		case *selfExpr:
+			e.pos = f(e.pos)
+		case *numberLit:
+			e.pos = f(e.pos)
+		case *stringLit:
+			e.pos, e.end = f(e.pos), f(e.end)
+		case *placeholder:
+			e.pos = f(e.pos)
+		case *returnStmt:
+			e.pos = f(e.pos)
+		case *object:
+			e.pos, e.end = f(e.pos), f(e.end)
+			for _, s := range e.slots {
+				s.pos = f(s.pos)
+			}
+		case *block:
+			e.pos, e.end = f(e.pos), f(e.end)
+			for _, s := range e.slots {
+				s.pos = f(s.pos)
+			}
+		}
+		return true
+	})
+}
+
+// Shift moves every position in the tree rooted at n by delta bytes, as if
+// delta bytes had been inserted before it in the input, so that a tree
+// parsed from a fragment can be positioned in the file containing it. The
+// tree is modified in place.
+func Shift(n Node, delta int) {
+	mapPos(n, func(pos int) int { return pos + delta })
+}
+
+// ShiftLexemes returns a copy of lexemes with their positions moved as if
+// delta bytes, making up the given number of complete lines, had been
+// inserted before them in the input. Columns are unaffected by whole lines.
+func ShiftLexemes(lexemes []Lexeme, delta, lines int) []Lexeme {
+	shifted := make([]Lexeme, len(lexemes))
+	for i, l := range lexemes {
+		l.Pos += delta
+		l.End += delta
+		l.Line += lines
+		shifted[i] = l
+	}
+	return shifted
+}
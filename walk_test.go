@@ -0,0 +1,56 @@
+package ego
+
+import (
+	"reflect"
+	"testing"
+)
+
+func collect(items <-chan item) (all []item) {
+	for i := range items {
+		all = append(all, i)
+	}
+	return
+}
+
+func TestShift(t *testing.T) {
+	const header = "\"generated\"\n"
+	const source = "(| x = y. + a = (a foo: b) |) bar - baz"
+
+	shifted := ShiftLexemes(lexemes(source, collect(lex("test", source, Config{}))), len(header), 1)
+	if relexed := lexemes(header+source, collect(lex("test", header+source, Config{}))); !reflect.DeepEqual(shifted, relexed) {
+		t.Errorf("expected %v but found %v", relexed, shifted)
+	}
+
+	p := parse("test", source, Config{})
+	e := p.parseProgram()
+	p.close()
+	Shift(e, len(header))
+	p = parse("test", header+source, Config{})
+	reparsed := p.parseProgram()
+	p.close()
+	if !reflect.DeepEqual(e, reparsed) {
+		t.Errorf("expected %#v but found %#v", reparsed, e)
+	}
+}
+
+func TestWalkCount(t *testing.T) {
+	n, errs := Parse("test", "(| x = 3 | [:a | ^a + 'b'] value: self foo: x) bar")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	count := func(skipBlocks bool) (nodes int) {
+		Walk(n, func(e Node) bool {
+			nodes++
+			_, isBlock := e.(*block)
+			return !skipBlocks || !isBlock
+		})
+		return
+	}
+	// sequence, bar, object, 3, value:, block, ^, +, a, 'b', foo:, self, x
+	if nodes := count(false); nodes != 13 {
+		t.Errorf("expected 13 nodes but found %d", nodes)
+	}
+	if nodes := count(true); nodes != 9 {
+		t.Errorf("expected 9 nodes outside the block's body but found %d", nodes)
+	}
+}